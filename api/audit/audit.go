@@ -0,0 +1,162 @@
+// Package audit records changes to the audit log.
+//
+// Every write goes through Record in the transaction that makes it, so an
+// entry exists exactly when the change does. Who made the change is taken from
+// the Metadata stored in the context with WithMetadata.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Actions
+const (
+	Create  = "create"
+	Update  = "update"
+	Delete  = "delete"
+	Restore = "restore"
+	Purge   = "purge"
+)
+
+// SystemActor is the actor kind of changes made by background jobs
+const SystemActor = "system"
+
+// Entity types
+const (
+	Product           = "product"
+	Category          = "category"
+	CategoryAttribute = "category_attribute"
+	Supplier          = "supplier"
+	ProductUnit       = "product_unit"
+	ProductImage      = "product_image"
+	Order             = "order"
+	OrderItem         = "order_item"
+	PurchaseOrder     = "purchase_order"
+	User              = "user"
+	APIKey            = "api_key"
+	Tenant            = "tenant"
+)
+
+type metadataKey struct{}
+
+// Metadata describes who made a change and from which request
+type Metadata struct {
+	ActorKind string
+	ActorID   uuid.UUID
+	RequestID string
+	ClientIP  string
+}
+
+// WithMetadata stores the actor of the changes made with ctx
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+// redacted stands in for the value of a changed field hidden from JSON
+const redacted = "[redacted]"
+
+// fields returns the values to compare for an audit entry. Models are
+// reduced to their columns, leaving out relations; columns hidden from JSON,
+// such as password hashes, are returned separately so their values never reach
+// the log. Other values are compared by their JSON fields.
+func fields(idb bun.IDB, value interface{}) (fields, hidden map[string]interface{}, err error) {
+	fields = map[string]interface{}{}
+	hidden = map[string]interface{}{}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return fields, hidden, nil
+	}
+	v = reflect.Indirect(v)
+
+	snapshot := value
+	if v.Kind() == reflect.Struct {
+		columns := map[string]interface{}{}
+		for _, field := range idb.Dialect().Tables().Get(v.Type()).Fields {
+			if field.StructField.Tag.Get("json") == "-" {
+				hidden[field.Name] = field.Value(v).Interface()
+				continue
+			}
+			columns[field.Name] = field.Value(v).Interface()
+		}
+		snapshot = columns
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	return fields, hidden, nil
+}
+
+// diff returns the fields whose value differs between before and after
+func diff(idb bun.IDB, before, after interface{}) (map[string]models.AuditChange, error) {
+	beforeFields, beforeHidden, err := fields(idb, before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, afterHidden, err := fields(idb, after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]models.AuditChange{}
+	for name, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[name]) {
+			changes[name] = models.AuditChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, seen := beforeFields[name]; !seen && value != nil {
+			changes[name] = models.AuditChange{After: value}
+		}
+	}
+	// Hidden fields only show that they changed, and only on updates
+	for name, value := range beforeHidden {
+		if afterValue, ok := afterHidden[name]; ok && !reflect.DeepEqual(value, afterValue) {
+			changes[name] = models.AuditChange{Before: redacted, After: redacted}
+		}
+	}
+	return changes, nil
+}
+
+// Record writes an audit entry for a change. It must be given the
+// transaction the change was made in, so the entry is kept only if the change
+// is. Updates that change nothing are not recorded.
+func Record(ctx context.Context, idb bun.IDB, action, entityType string, entityID interface{}, before, after interface{}) error {
+	changes, err := diff(idb, before, after)
+	if err != nil {
+		return fmt.Errorf("audit %s %s: %w", action, entityType, err)
+	}
+	if action == Update && len(changes) == 0 {
+		return nil
+	}
+
+	metadata, _ := ctx.Value(metadataKey{}).(Metadata)
+	_, err = idb.NewInsert().Model(&models.AuditEntry{
+		ActorKind:  metadata.ActorKind,
+		ActorID:    metadata.ActorID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Changes:    changes,
+		RequestID:  metadata.RequestID,
+		ClientIP:   metadata.ClientIP,
+	}).Exec(ctx)
+	return err
+}
+
+// EntityID returns the primary key of a model
+func EntityID(idb bun.IDB, model interface{}) interface{} {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	return idb.Dialect().Tables().Get(v.Type()).PKs[0].Value(v).Interface()
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyHeader is the request header machine clients send their key in
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognise
+const apiKeyPrefix = "ims"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyLookup resolves a presented key to a principal. It is set by main to
+// the database-backed implementation.
+var APIKeyLookup func(c *fiber.Ctx, prefix, secretHash string) (*Principal, error)
+
+// GenerateAPIKey returns a new key in the form ims_<prefix>_<secret>, its
+// prefix and the hash to store
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	key = apiKeyPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes a key for storage. Keys are random and long, so a fast
+// hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKey splits a presented key into its prefix and hash
+func ParseAPIKey(key string) (prefix, hash string, err error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", ErrInvalidAPIKey
+	}
+	return parts[1], HashAPIKey(key), nil
+}
+
+// HashesEqual compares key hashes in constant time
+func HashesEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// ValidScope reports whether a scope can be granted to an API key. Managing
+// users and keys is reserved for people.
+func ValidScope(scope string) bool {
+	if scope == UsersManage || scope == TenantsManage {
+		return false
+	}
+	for _, permission := range AllPermissions {
+		if permission == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// principalKey is the fiber.Ctx Locals key the authenticated principal is stored under
+const principalKey = "principal"
+
+// Principal kinds
+const (
+	PrincipalUser   = "user"
+	PrincipalAPIKey = "api_key"
+)
+
+// Principal is the authenticated caller of a request: a user, whose
+// permissions come from their role, or an API key, limited to its scopes.
+// Either way it acts on behalf of exactly one tenant.
+type Principal struct {
+	Kind     string
+	ID       uuid.UUID
+	TenantID uuid.UUID
+	Role     string
+	Scopes   []string
+}
+
+// Can reports whether the principal has a permission
+func (p *Principal) Can(permission string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Kind == PrincipalAPIKey {
+		for _, scope := range p.Scopes {
+			if scope == permission {
+				return true
+			}
+		}
+		return false
+	}
+	return RoleAllows(p.Role, permission)
+}
+
+// FromContext returns the principal Authenticate stored for the request, or nil
+func FromContext(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalKey).(*Principal)
+	return principal
+}
+
+// Authenticate requires a valid access token in the Authorization header, or
+// an API key in the X-API-Key header
+func Authenticate(c *fiber.Ctx) error {
+	if key := c.Get(APIKeyHeader); key != "" {
+		return authenticateAPIKey(c, key)
+	}
+
+	header := c.Get(fiber.HeaderAuthorization)
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		return problem.Unauthorized("authentication_required", "Authentication required")
+	}
+
+	claims, err := ParseToken(token, AccessToken)
+	if err != nil {
+		return problem.Unauthorized("invalid_token", "Invalid or expired token")
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return problem.Unauthorized("invalid_token", "Invalid or expired token")
+	}
+	tenantID, err := uuid.Parse(claims.Tenant)
+	if err != nil {
+		return problem.Unauthorized("invalid_token", "Invalid or expired token")
+	}
+
+	c.Locals(principalKey, &Principal{Kind: PrincipalUser, ID: userID, TenantID: tenantID, Role: claims.Role})
+	return c.Next()
+}
+
+func authenticateAPIKey(c *fiber.Ctx, key string) error {
+	prefix, hash, err := ParseAPIKey(key)
+	if err != nil || APIKeyLookup == nil {
+		return problem.Unauthorized("invalid_api_key", "Invalid API key")
+	}
+
+	principal, err := APIKeyLookup(c, prefix, hash)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		return problem.Unauthorized("invalid_api_key", "Invalid API key")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to verify API key")
+	}
+
+	c.Locals(principalKey, principal)
+	return c.Next()
+}
+
+// Require allows the request through only if the principal has every permission
+func Require(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := FromContext(c)
+		for _, permission := range permissions {
+			if !principal.Can(permission) {
+				return problem.Forbidden("forbidden", "Forbidden").WithDetail("Missing permission " + permission)
+			}
+		}
+		return c.Next()
+	}
+}
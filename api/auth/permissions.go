@@ -0,0 +1,82 @@
+package auth
+
+// Roles a user account can have
+const (
+	RoleSuperadmin = "superadmin"
+	RoleAdmin      = "admin"
+	RoleManager    = "manager"
+	RoleClerk      = "clerk"
+	RoleViewer     = "viewer"
+)
+
+// Permissions checked by Require, named resource:action
+const (
+	ProductsRead     = "products:read"
+	ProductsWrite    = "products:write"
+	ProductsDelete   = "products:delete"
+	PricesWrite      = "prices:write"
+	StockWrite       = "stock:write"
+	SalesWrite       = "sales:write"
+	CategoriesRead   = "categories:read"
+	CategoriesWrite  = "categories:write"
+	CategoriesDelete = "categories:delete"
+	SuppliersRead    = "suppliers:read"
+	SuppliersWrite   = "suppliers:write"
+	SuppliersDelete  = "suppliers:delete"
+	AuditRead        = "audit:read"
+	UsersManage      = "users:manage"
+	TenantsManage    = "tenants:manage"
+)
+
+// AllPermissions lists every permission, in the order they are documented
+var AllPermissions = []string{
+	ProductsRead, ProductsWrite, ProductsDelete, PricesWrite, StockWrite, SalesWrite,
+	CategoriesRead, CategoriesWrite, CategoriesDelete,
+	SuppliersRead, SuppliersWrite, SuppliersDelete,
+	AuditRead, UsersManage, TenantsManage,
+}
+
+var readPermissions = []string{ProductsRead, CategoriesRead, SuppliersRead}
+
+// rolePermissions is the permission matrix. Admins manage their own tenant;
+// only superadmins can create tenants. Clerks can sell and book stock but
+// cannot change prices or delete anything.
+var rolePermissions = map[string][]string{
+	RoleSuperadmin: AllPermissions,
+	RoleAdmin:      without(AllPermissions, TenantsManage),
+	RoleManager: append([]string{
+		ProductsWrite, ProductsDelete, PricesWrite, StockWrite, SalesWrite,
+		CategoriesWrite, CategoriesDelete,
+		SuppliersWrite, SuppliersDelete,
+		AuditRead,
+	}, readPermissions...),
+	RoleClerk:  append([]string{StockWrite, SalesWrite}, readPermissions...),
+	RoleViewer: readPermissions,
+}
+
+// without returns permissions minus excluded
+func without(permissions []string, excluded string) []string {
+	var kept []string
+	for _, permission := range permissions {
+		if permission != excluded {
+			kept = append(kept, permission)
+		}
+	}
+	return kept
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleAllows reports whether role grants permission
+func RoleAllows(role, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
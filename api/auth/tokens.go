@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Token types, carried in the "typ" claim so a refresh token cannot be used as an access token
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the JWT claims of access and refresh tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	Tenant string `json:"tid"`
+	Role   string `json:"role"`
+	Type   string `json:"typ"`
+}
+
+// TokenConfig holds the signing key and token lifetimes
+type TokenConfig struct {
+	Secret     []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+var config TokenConfig
+
+// Configure sets the signing key and token lifetimes. It must be called
+// before tokens are issued or verified.
+func Configure(tokens TokenConfig) {
+	config = tokens
+}
+
+// IssueToken signs a token of the given type for a user of a tenant. The
+// returned ID is the token's jti, used to track refresh tokens.
+func IssueToken(tokenType string, userID, tenantID uuid.UUID, role string) (token string, id uuid.UUID, expiresAt time.Time, err error) {
+	ttl := config.AccessTTL
+	if tokenType == RefreshToken {
+		ttl = config.RefreshTTL
+	}
+
+	now := time.Now()
+	id = uuid.New()
+	expiresAt = now.Add(ttl)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Tenant: tenantID.String(),
+		Role:   role,
+		Type:   tokenType,
+	}
+
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.Secret)
+	return token, id, expiresAt, err
+}
+
+// ParseToken verifies a token's signature, expiry and type
+func ParseToken(token, tokenType string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return config.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("%w: expected %s token", ErrInvalidToken, tokenType)
+	}
+	return &claims, nil
+}
+
+// HashPassword hashes a password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches a bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// dummyHash is a hash of no account's password, made at the cost of the
+// hashes HashPassword makes
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("no account has this password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// RejectPassword takes as long as CheckPassword and always fails. Logins
+// for unknown or disabled accounts use it so that how long they take does not
+// give away which accounts exist.
+func RejectPassword(password string) bool {
+	bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+	return false
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRejectPassword(t *testing.T) {
+	// Comparing against a cheaper hash would answer sooner than for a real account
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := bcrypt.Cost([]byte(hash))
+	if cost, err := bcrypt.Cost(dummyHash()); err != nil || cost != want {
+		t.Errorf("dummy hash has cost %d (%v), want %d", cost, err, want)
+	}
+
+	if RejectPassword("no account has this password") {
+		t.Error("RejectPassword accepted the dummy hash's password")
+	}
+}
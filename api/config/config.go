@@ -0,0 +1,394 @@
+// Package config holds the settings of the API.
+//
+// Settings are taken from, in increasing order of precedence: the defaults
+// from Default, a YAML or TOML file, environment variables, which may also be given in
+// a .env file, and command-line flags. Every setting has a key, such as
+// database.max_open_conns, which is its place in the file and the name of its
+// flag (-database.max_open_conns), and an environment variable, such as
+// DB_MAX_OPEN_CONNS. Secrets have no flag, so they never show up in the
+// process list.
+//
+// Load reads the sources and Validate checks the result, reporting every
+// setting that is wrong at once, by its key and variable.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the API
+type Config struct {
+	Server   Server   `yaml:"server" toml:"server"`
+	TLS      TLS      `yaml:"tls" toml:"tls"`
+	CORS     CORS     `yaml:"cors" toml:"cors"`
+	Database Database `yaml:"database" toml:"database"`
+	Logging  Logging  `yaml:"logging" toml:"logging"`
+	Auth     Auth     `yaml:"auth" toml:"auth"`
+	Storage  Storage  `yaml:"storage" toml:"storage"`
+	Features Features `yaml:"features" toml:"features"`
+	Purge    Purge    `yaml:"purge" toml:"purge"`
+	Metrics  Metrics  `yaml:"metrics" toml:"metrics"`
+	Tracing  Tracing  `yaml:"tracing" toml:"tracing"`
+
+	// loadErrs are the settings Load could not parse, reported by Validate
+	loadErrs Errors
+}
+
+type Server struct {
+	Port               int           `yaml:"port" toml:"port" env:"PORT" usage:"Port the API listens on"`
+	RequestTimeout     time.Duration `yaml:"request_timeout" toml:"request_timeout" env:"REQUEST_TIMEOUT" usage:"Deadline of requests"`
+	LongRequestTimeout time.Duration `yaml:"long_request_timeout" toml:"long_request_timeout" env:"LONG_REQUEST_TIMEOUT" usage:"Deadline of bulk requests and image uploads"`
+	ShutdownDelay      time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SHUTDOWN_DELAY" usage:"How long /readyz fails before the server stops accepting connections"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"How long requests in flight may take to finish on shutdown"`
+}
+
+// TLS makes the API serve HTTPS when both files are set
+type TLS struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain"`
+	KeyFile  string `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// CORS lets browsers on other origins call the API. It is off while no
+// origins are allowed.
+type CORS struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" usage:"Comma-separated origins allowed to call the API, or *"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS" usage:"Let browsers send cookies and credentials"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" usage:"How long browsers may cache a preflight response"`
+}
+
+type Database struct {
+	URL             string        `yaml:"url" toml:"url" env:"DATABASE_URL" secret:"true" usage:"Postgres connection URL"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"Most connections open at once"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"Most idle connections kept open"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"How long a connection is reused, 0 for ever"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" usage:"How long a connection may stay idle, 0 for ever"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" usage:"text or json"`
+}
+
+type Auth struct {
+	JWTSecret     string        `yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"Signing key of tokens, at least 32 characters"`
+	AccessTTL     time.Duration `yaml:"access_ttl" toml:"access_ttl" env:"JWT_ACCESS_TTL" usage:"Access token lifetime"`
+	RefreshTTL    time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl" env:"JWT_REFRESH_TTL" usage:"Refresh token lifetime"`
+	AdminUsername string        `yaml:"admin_username" toml:"admin_username" env:"ADMIN_USERNAME" usage:"Creates the first superadmin when no users exist"`
+	AdminPassword string        `yaml:"admin_password" toml:"admin_password" env:"ADMIN_PASSWORD" secret:"true" usage:"Password of the first superadmin"`
+}
+
+type Storage struct {
+	Backend    string `yaml:"backend" toml:"backend" env:"STORAGE_BACKEND" usage:"Where images are stored, local or s3"`
+	LocalDir   string `yaml:"local_dir" toml:"local_dir" env:"STORAGE_LOCAL_DIR" usage:"Directory of the local backend"`
+	PublicURL  string `yaml:"public_url" toml:"public_url" env:"STORAGE_PUBLIC_URL" usage:"URL prefix images are served from"`
+	S3Endpoint string `yaml:"s3_endpoint" toml:"s3_endpoint" env:"S3_ENDPOINT" usage:"Host and port of the S3-compatible service"`
+	S3Access   string `yaml:"s3_access_key" toml:"s3_access_key" env:"S3_ACCESS_KEY" secret:"true" usage:"S3 access key"`
+	S3Secret   string `yaml:"s3_secret_key" toml:"s3_secret_key" env:"S3_SECRET_KEY" secret:"true" usage:"S3 secret key"`
+	S3Bucket   string `yaml:"s3_bucket" toml:"s3_bucket" env:"S3_BUCKET" usage:"S3 bucket"`
+	S3Region   string `yaml:"s3_region" toml:"s3_region" env:"S3_REGION" usage:"S3 region"`
+	S3UseSSL   bool   `yaml:"s3_use_ssl" toml:"s3_use_ssl" env:"S3_USE_SSL" usage:"Reach S3 over HTTPS"`
+
+	MaxImagePixels int `yaml:"max_image_pixels" toml:"max_image_pixels" env:"MAX_IMAGE_PIXELS" usage:"Largest image accepted for upload, in pixels (width times height)"`
+}
+
+// Features turns behavior of the API on and off
+type Features struct {
+	RequireIfMatch bool          `yaml:"require_if_match" toml:"require_if_match" env:"REQUIRE_IF_MATCH" usage:"Refuse writes to versioned records without If-Match"`
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" usage:"How long responses are replayed for an Idempotency-Key"`
+	Purge          bool          `yaml:"purge" toml:"purge" env:"PURGE_ENABLED" usage:"Run the job that removes old deleted records"`
+	Metrics        bool          `yaml:"metrics" toml:"metrics" env:"METRICS_ENABLED" usage:"Serve Prometheus metrics at /metrics"`
+	Tracing        bool          `yaml:"tracing" toml:"tracing" env:"TRACING_ENABLED" usage:"Export OpenTelemetry traces of requests and queries"`
+}
+
+// Purge controls when soft-deleted records are removed for good
+type Purge struct {
+	Retention time.Duration `yaml:"retention" toml:"retention" env:"PURGE_RETENTION" usage:"How long deleted records can be restored"`
+	Interval  time.Duration `yaml:"interval" toml:"interval" env:"PURGE_INTERVAL" usage:"How often the purge job runs"`
+}
+
+// Metrics controls the Prometheus metrics
+type Metrics struct {
+	KPIInterval time.Duration `yaml:"kpi_interval" toml:"kpi_interval" env:"METRICS_KPI_INTERVAL" usage:"How often the stock and order metrics are recomputed"`
+}
+
+// Tracing controls where traces are exported to
+type Tracing struct {
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"URL of the OTLP/HTTP collector traces are sent to"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME" usage:"Name of the API in traces"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"Share of requests traced, from 0 to 1, unless the caller decided"`
+}
+
+// Default returns the settings used where no source sets them
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:               3000,
+			RequestTimeout:     30 * time.Second,
+			LongRequestTimeout: 2 * time.Minute,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		Database: Database{
+			MaxOpenConns: 25,
+			MaxIdleConns: 25,
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: "text",
+		},
+		Auth: Auth{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 7 * 24 * time.Hour,
+		},
+		Storage: Storage{
+			Backend:  "local",
+			LocalDir: "uploads",
+			S3UseSSL: true,
+
+			MaxImagePixels: 25_000_000,
+		},
+		Features: Features{
+			RequireIfMatch: true,
+			IdempotencyTTL: 24 * time.Hour,
+			Purge:          true,
+			Metrics:        true,
+		},
+		Purge: Purge{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  24 * time.Hour,
+		},
+		Metrics: Metrics{
+			KPIInterval: time.Minute,
+		},
+		Tracing: Tracing{
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "ims-zedeks",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Validate checks the settings the API needs to serve requests
+func (c *Config) Validate() error {
+	errs := slices.Clone(c.loadErrs)
+	c.Server.validate(&errs)
+	c.TLS.validate(&errs)
+	c.CORS.validate(&errs)
+	c.Database.validate(&errs)
+	c.Logging.validate(&errs)
+	c.Auth.validate(&errs)
+	c.Storage.validate(&errs)
+	c.Features.validate(&errs)
+	if c.Features.Purge {
+		c.Purge.validate(&errs)
+	}
+	if c.Features.Metrics {
+		c.Metrics.validate(&errs)
+	}
+	if c.Features.Tracing {
+		c.Tracing.validate(&errs)
+	}
+	return errs.Err()
+}
+
+// ValidateDatabase checks only the database settings, which are all the
+// migrate command needs
+func (c *Config) ValidateDatabase() error {
+	var errs Errors
+	for _, err := range c.loadErrs {
+		if strings.HasPrefix(err.Key, "database.") {
+			errs = append(errs, err)
+		}
+	}
+	c.Database.validate(&errs)
+	return errs.Err()
+}
+
+func (s Server) validate(errs *Errors) {
+	if s.Port < 1 || s.Port > 65535 {
+		errs.Addf("server.port", "must be between 1 and 65535, not %d", s.Port)
+	}
+	positive(errs, "server.request_timeout", s.RequestTimeout)
+	positive(errs, "server.long_request_timeout", s.LongRequestTimeout)
+	if s.ShutdownDelay < 0 {
+		errs.Addf("server.shutdown_delay", "must not be negative")
+	}
+	positive(errs, "server.shutdown_timeout", s.ShutdownTimeout)
+}
+
+func (t TLS) validate(errs *Errors) {
+	if !t.Enabled() {
+		return
+	}
+	if t.CertFile == "" {
+		errs.Addf("tls.cert_file", "is required when tls.key_file is set")
+	}
+	if t.KeyFile == "" {
+		errs.Addf("tls.key_file", "is required when tls.cert_file is set")
+	}
+	readable(errs, "tls.cert_file", t.CertFile)
+	readable(errs, "tls.key_file", t.KeyFile)
+}
+
+func (c CORS) validate(errs *Errors) {
+	// Browsers refuse credentials from a wildcard origin
+	if c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		errs.Addf("cors.allow_credentials", "cannot be used when cors.allowed_origins is *")
+	}
+	if c.MaxAge < 0 {
+		errs.Addf("cors.max_age", "must not be negative")
+	}
+}
+
+func (d Database) validate(errs *Errors) {
+	if d.URL == "" {
+		errs.Addf("database.url", "is required")
+	}
+	if d.MaxOpenConns < 1 {
+		errs.Addf("database.max_open_conns", "must be at least 1, not %d", d.MaxOpenConns)
+	}
+	if d.MaxIdleConns < 0 || d.MaxIdleConns > d.MaxOpenConns {
+		errs.Addf("database.max_idle_conns", "must be between 0 and database.max_open_conns (%d), not %d", d.MaxOpenConns, d.MaxIdleConns)
+	}
+	if d.ConnMaxLifetime < 0 {
+		errs.Addf("database.conn_max_lifetime", "must not be negative")
+	}
+	if d.ConnMaxIdleTime < 0 {
+		errs.Addf("database.conn_max_idle_time", "must not be negative")
+	}
+}
+
+func (l Logging) validate(errs *Errors) {
+	oneOf(errs, "logging.level", l.Level, "debug", "info", "warn", "error")
+	oneOf(errs, "logging.format", l.Format, "text", "json")
+}
+
+func (a Auth) validate(errs *Errors) {
+	if len(a.JWTSecret) < 32 {
+		errs.Addf("auth.jwt_secret", "must be set to at least 32 characters")
+	}
+	positive(errs, "auth.access_ttl", a.AccessTTL)
+	positive(errs, "auth.refresh_ttl", a.RefreshTTL)
+	if (a.AdminUsername == "") != (a.AdminPassword == "") {
+		errs.Addf("auth.admin_password", "and auth.admin_username must be set together")
+	}
+}
+
+func (s Storage) validate(errs *Errors) {
+	oneOf(errs, "storage.backend", s.Backend, "local", "s3")
+	if s.MaxImagePixels < 1 {
+		errs.Addf("storage.max_image_pixels", "must be at least 1, not %d", s.MaxImagePixels)
+	}
+	if s.Backend != "s3" {
+		return
+	}
+	required := []struct{ key, value string }{
+		{"storage.s3_endpoint", s.S3Endpoint},
+		{"storage.s3_access_key", s.S3Access},
+		{"storage.s3_secret_key", s.S3Secret},
+		{"storage.s3_bucket", s.S3Bucket},
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			errs.Addf(setting.key, "is required when storage.backend is s3")
+		}
+	}
+}
+
+func (f Features) validate(errs *Errors) {
+	positive(errs, "features.idempotency_ttl", f.IdempotencyTTL)
+}
+
+func (p Purge) validate(errs *Errors) {
+	positive(errs, "purge.retention", p.Retention)
+	positive(errs, "purge.interval", p.Interval)
+}
+
+func (m Metrics) validate(errs *Errors) {
+	positive(errs, "metrics.kpi_interval", m.KPIInterval)
+}
+
+func (t Tracing) validate(errs *Errors) {
+	endpoint, err := url.Parse(t.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		errs.Addf("tracing.endpoint", "must be an http or https URL such as http://localhost:4318, not %q", t.Endpoint)
+	}
+	if t.ServiceName == "" {
+		errs.Addf("tracing.service_name", "is required")
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		errs.Addf("tracing.sample_ratio", "must be between 0 and 1, not %g", t.SampleRatio)
+	}
+}
+
+func positive(errs *Errors, key string, duration time.Duration) {
+	if duration <= 0 {
+		errs.Addf(key, "must be longer than 0, not %s", duration)
+	}
+}
+
+func oneOf(errs *Errors, key, value string, allowed ...string) {
+	if !slices.Contains(allowed, value) {
+		errs.Addf(key, "must be one of %v, not %q", allowed, value)
+	}
+}
+
+func readable(errs *Errors, key, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		errs.Addf(key, "cannot be read: %s", err)
+	}
+}
+
+// Error is a setting that is wrong
+type Error struct {
+	Key     string
+	Message string
+}
+
+func (e Error) Error() string {
+	name := e.Key
+	if s, ok := settingByKey(e.Key); ok && s.env != "" {
+		name += " (" + s.env + ")"
+	}
+	return name + " " + e.Message
+}
+
+// Errors lists the settings that are wrong
+type Errors []Error
+
+// Addf records that the setting with key is wrong
+func (e *Errors) Addf(key, format string, args ...interface{}) {
+	*e = append(*e, Error{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e, or nil if it is empty
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error lists the settings one per line
+func (e Errors) Error() string {
+	message := fmt.Sprintf("%d settings are wrong:", len(e))
+	if len(e) == 1 {
+		message = "1 setting is wrong:"
+	}
+	for _, err := range e {
+		message += "\n  " + err.Error()
+	}
+	return message
+}
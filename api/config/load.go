@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the configuration file when the -config flag does not
+const FileEnv = "CONFIG_FILE"
+
+// setting is one field of Config with the names it is set by
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	// index is the path to the field from Config
+	index []int
+	typ   reflect.Type
+}
+
+// settings are the fields of Config, section by section
+var settings = func() []setting {
+	var all []setting
+	root := reflect.TypeOf(Config{})
+	for i := range root.NumField() {
+		section := root.Field(i)
+		if !section.IsExported() {
+			continue
+		}
+		for j := range section.Type.NumField() {
+			field := section.Type.Field(j)
+			all = append(all, setting{
+				key:    yamlName(section) + "." + yamlName(field),
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				index:  []int{i, j},
+				typ:    field.Type,
+			})
+		}
+	}
+	return all
+}()
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return name
+}
+
+func settingByKey(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// Load returns the configuration from the defaults, the configuration file,
+// the environment and the flags in args, along with the arguments after the
+// flags. The file is named by the -config flag or CONFIG_FILE; without one,
+// only the other sources are used. Load fails for flags it does not know and
+// files it cannot read; values that cannot be parsed are reported by Validate
+// along with the other wrong settings.
+func Load(args []string) (*Config, []string, error) {
+	var errs Errors
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs.Addf(".env", "cannot be read: %s", err)
+	}
+
+	flags := flag.NewFlagSet("ims-zedeks", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(FileEnv), "YAML or TOML configuration file")
+	values := make([]flagValue, len(settings))
+	for i, s := range settings {
+		if s.secret {
+			continue
+		}
+		values[i].isBool = s.typ.Kind() == reflect.Bool
+		flags.Var(&values[i], s.key, s.usage+" ("+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	config := Default()
+	if *path != "" {
+		if err := config.readFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	target := reflect.ValueOf(config).Elem()
+	for i, s := range settings {
+		raw, ok := os.LookupEnv(s.env)
+		if value := values[i]; value.set {
+			raw, ok = value.value, true
+		} else if raw == "" {
+			// Empty variables are left unset, as in a .env file copied from an example
+			ok = false
+		}
+		if !ok {
+			continue
+		}
+		if err := parse(target.FieldByIndex(s.index), raw); err != nil {
+			errs.Addf(s.key, "%s", err)
+		}
+	}
+	config.loadErrs = errs
+	return config, flags.Args(), nil
+}
+
+// readFile applies the settings in the file at path, which is TOML if its
+// name ends in .toml and YAML otherwise. Unknown keys are an error, so that
+// misspelled settings do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("configuration file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("configuration file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("configuration file %s: unknown setting %s", path, undecoded[0])
+		}
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// parse sets field from its text form, as given in the environment or a flag
+func parse(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case time.Duration:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("is not a duration such as 30s or 5m: %q", raw)
+		}
+		field.SetInt(int64(duration))
+	case int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("is not a whole number: %q", raw)
+		}
+		field.SetInt(int64(number))
+	case float64:
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("is not a number: %q", raw)
+		}
+		field.SetFloat(number)
+	case bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("is not true or false: %q", raw)
+		}
+		field.SetBool(value)
+	case string:
+		field.SetString(raw)
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("has the unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// flagValue keeps the text of a flag, which is parsed along with the
+// environment once the file has been read
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value, v.set = value, true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to a file named name in a temporary directory
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  port: 9090\n  request_timeout: 45s\ncors:\n  allowed_origins: [https://app.example.com]\n",
+		"config.toml": "[server]\nport = 9090\nrequest_timeout = \"45s\"\n\n[cors]\nallowed_origins = [\"https://app.example.com\"]\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			config := Default()
+			if err := config.readFile(writeFile(t, name, content)); err != nil {
+				t.Fatal(err)
+			}
+			if config.Server.Port != 9090 || config.Server.RequestTimeout != 45*time.Second {
+				t.Errorf("server settings are %+v", config.Server)
+			}
+			if len(config.CORS.AllowedOrigins) != 1 || config.CORS.AllowedOrigins[0] != "https://app.example.com" {
+				t.Errorf("allowed origins are %v", config.CORS.AllowedOrigins)
+			}
+			// Settings the file leaves out keep their defaults
+			if config.Database.MaxOpenConns != Default().Database.MaxOpenConns {
+				t.Errorf("max_open_conns is %d, want the default", config.Database.MaxOpenConns)
+			}
+		})
+	}
+}
+
+// sample returns a value for a setting that no default has, as YAML and TOML
+// text and as the value it should decode to
+func sample(t *testing.T, s setting) (yamlText, tomlText string, want interface{}) {
+	t.Helper()
+	switch reflect.Zero(s.typ).Interface().(type) {
+	case time.Duration:
+		return "7m", `"7m"`, 7 * time.Minute
+	case int:
+		return "7", "7", 7
+	case float64:
+		return "0.7", "0.7", 0.7
+	case bool:
+		// Flipped below, as either value may be the default
+		return "", "", nil
+	case string:
+		return "sample", `"sample"`, "sample"
+	case []string:
+		return "[sample]", `["sample"]`, []string{"sample"}
+	}
+	t.Fatalf("%s has the unsupported type %s", s.key, s.typ)
+	return
+}
+
+func TestReadFileEverySetting(t *testing.T) {
+	defaults := reflect.ValueOf(Default()).Elem()
+	var yamlFile, tomlFile strings.Builder
+	want := map[string]interface{}{}
+	section := ""
+	for _, s := range settings {
+		name, key, _ := strings.Cut(s.key, ".")
+		if name != section {
+			section = name
+			fmt.Fprintf(&yamlFile, "%s:\n", name)
+			fmt.Fprintf(&tomlFile, "[%s]\n", name)
+		}
+		yamlText, tomlText, value := sample(t, s)
+		if s.typ.Kind() == reflect.Bool {
+			flipped := !defaults.FieldByIndex(s.index).Bool()
+			yamlText, tomlText, value = strconv.FormatBool(flipped), strconv.FormatBool(flipped), flipped
+		}
+		fmt.Fprintf(&yamlFile, "  %s: %s\n", key, yamlText)
+		fmt.Fprintf(&tomlFile, "%s = %s\n", key, tomlText)
+		want[s.key] = value
+	}
+
+	files := map[string]string{"config.yaml": yamlFile.String(), "config.toml": tomlFile.String()}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			config := Default()
+			if err := config.readFile(writeFile(t, name, content)); err != nil {
+				t.Fatal(err)
+			}
+			read := reflect.ValueOf(config).Elem()
+			for _, s := range settings {
+				if got := read.FieldByIndex(s.index).Interface(); !reflect.DeepEqual(got, want[s.key]) {
+					t.Errorf("%s is %v, want %v", s.key, got, want[s.key])
+				}
+			}
+		})
+	}
+}
+
+func TestReadFileUnknownKey(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  prot: 9090\n",
+		"config.toml": "[server]\nprot = 9090\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			err := Default().readFile(writeFile(t, name, content))
+			if err == nil || !strings.Contains(err.Error(), "prot") {
+				t.Errorf("misspelled setting gave %v, want an error naming it", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type idbKey struct{}
+
+// WithIDB returns a context whose queries should run on idb, such as the
+// connection scoped to the request's tenant or the transaction they belong to
+func WithIDB(ctx context.Context, idb bun.IDB) context.Context {
+	return context.WithValue(ctx, idbKey{}, idb)
+}
+
+// IDB returns the connection or transaction stored in ctx by WithIDB, or
+// fallback if there is none
+func IDB(ctx context.Context, fallback bun.IDB) bun.IDB {
+	if idb, ok := ctx.Value(idbKey{}).(bun.IDB); ok {
+		return idb
+	}
+	return fallback
+}
+
+// StatementTimeout returns the statement_timeout, in milliseconds, that makes
+// Postgres cancel statements still running at ctx's deadline, and false if
+// ctx has no deadline
+func StatementTimeout(ctx context.Context) (string, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return "", false
+	}
+	// Zero would turn the timeout off
+	milliseconds := max(time.Until(deadline).Milliseconds(), 1)
+	return strconv.FormatInt(milliseconds, 10), true
+}
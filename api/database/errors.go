@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// SQLSTATE codes of the Postgres errors the API tells apart
+const (
+	UniqueViolation      = "23505"
+	ForeignKeyViolation  = "23503"
+	CheckViolation       = "23514"
+	SerializationFailure = "40001"
+	DeadlockDetected     = "40P01"
+	QueryCanceled        = "57014"
+)
+
+// Kinds of database errors, matched with errors.Is
+var (
+	// ErrDuplicate is a row that repeats the key of a unique index
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrForeignKey is a reference to a row that does not exist, or the
+	// removal of a row that is still referenced
+	ErrForeignKey = errors.New("foreign key violation")
+	// ErrCheck is a value a check constraint refuses
+	ErrCheck = errors.New("check violation")
+	// ErrSerialization is a transaction that Postgres aborted because it
+	// conflicted with a concurrent one, including deadlocks. Trying the
+	// transaction again usually succeeds.
+	ErrSerialization = errors.New("serialization failure")
+	// ErrTimeout is a statement that Postgres canceled, because it ran past
+	// statement_timeout or was canceled on the client's behalf
+	ErrTimeout = errors.New("query canceled")
+)
+
+// MaxTxAttempts is how often RunInTx tries a transaction that fails with
+// ErrSerialization
+var MaxTxAttempts = 3
+
+// Error is a Postgres error of one of the kinds above. Table and Constraint
+// name what was violated, as far as Postgres reports them.
+type Error struct {
+	Kind       error
+	Table      string
+	Constraint string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// SQLState returns the SQLSTATE of a Postgres error, or "" for other errors
+func SQLState(err error) string {
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Field('C')
+	}
+	return ""
+}
+
+// Translate wraps Postgres errors of the kinds above in an *Error. Other
+// errors, and errors that are already translated, are returned unchanged.
+func Translate(err error) error {
+	var translated *Error
+	if err == nil || errors.As(err, &translated) {
+		return err
+	}
+	var pgErr pgdriver.Error
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var kind error
+	switch pgErr.Field('C') {
+	case UniqueViolation:
+		kind = ErrDuplicate
+	case ForeignKeyViolation:
+		kind = ErrForeignKey
+	case CheckViolation:
+		kind = ErrCheck
+	case SerializationFailure, DeadlockDetected:
+		kind = ErrSerialization
+	case QueryCanceled:
+		kind = ErrTimeout
+	default:
+		return err
+	}
+	return &Error{Kind: kind, Table: pgErr.Field('t'), Constraint: pgErr.Field('n'), Err: err}
+}
+
+// IsTimeout reports whether err comes from running out of time: a context past
+// its deadline, a connection whose read or write deadline passed, or a
+// statement Postgres canceled
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) || SQLState(err) == QueryCanceled {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RunInTx runs fn in a transaction on idb, translating its error. The
+// context fn is given carries the transaction, see WithIDB. A
+// transaction that fails with a serialization failure or deadlock is run
+// again, up to MaxTxAttempts times, so fn must not have effects outside the
+// transaction that cannot be repeated. When idb is itself a transaction, fn
+// runs in a savepoint and is not retried here: the failure aborts the outer
+// transaction, which has to be retried as a whole.
+func RunInTx(ctx context.Context, idb bun.IDB, opts *sql.TxOptions, fn func(ctx context.Context, tx bun.Tx) error) error {
+	_, nested := idb.(bun.Tx)
+	for attempt := 1; ; attempt++ {
+		err := Translate(idb.RunInTx(ctx, opts, func(ctx context.Context, tx bun.Tx) error {
+			return fn(WithIDB(ctx, tx), tx)
+		}))
+		if !errors.Is(err, ErrSerialization) || nested || attempt >= MaxTxAttempts {
+			return err
+		}
+
+		// A short random pause keeps the conflicting transactions from
+		// meeting again straight away
+		pause := time.Duration(attempt)*10*time.Millisecond + rand.N(10*time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/uptrace/bun"
+)
+
+// defaultRotationOverlap is how long a rotated key keeps working when no overlap is given
+const defaultRotationOverlap = 24 * time.Hour
+
+// lastUsedResolution limits how often last_used_at is written for a busy key
+const lastUsedResolution = time.Minute
+
+// LookupAPIKey resolves a presented API key to a principal. It is installed as auth.APIKeyLookup.
+func LookupAPIKey(c *fiber.Ctx, prefix, secretHash string) (*auth.Principal, error) {
+	var key models.APIKey
+	err := db.NewSelect().Model(&key).Where("prefix = ?", prefix).Scan(requestContext(c))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "Failed to look up API key", "error", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	if !auth.HashesEqual(key.Hash, secretHash) ||
+		key.RevokedAt != nil ||
+		(key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		_, err := db.NewUpdate().
+			Model((*models.APIKey)(nil)).
+			Set("last_used_at = ?", now).
+			Where("id = ?", key.ID).
+			Exec(requestContext(c))
+		if err != nil {
+			slog.WarnContext(c.UserContext(), "Failed to record API key use", "error", err)
+		}
+	}
+
+	return &auth.Principal{
+		Kind:     auth.PrincipalAPIKey,
+		ID:       key.ID,
+		TenantID: key.TenantID,
+		Scopes:   key.Scopes,
+	}, nil
+}
+
+// newAPIKey generates a key for an integration. The plain key is only ever returned here.
+func newAPIKey(integration string, scopes []string, expiresAt *time.Time, createdBy *auth.Principal) (*models.APIKey, string, error) {
+	plain, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	return &models.APIKey{
+		Integration: integration,
+		Prefix:      prefix,
+		Hash:        hash,
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+		TenantID:    createdBy.TenantID,
+		CreatedBy:   createdBy.ID,
+	}, plain, nil
+}
+
+// Get all API keys
+func GetAllAPIKeys(c *fiber.Ctx) error {
+	query := db.NewSelect().
+		Model((*models.APIKey)(nil)).
+		Where("tenant_id = ?", auth.FromContext(c).TenantID).
+		Order("integration", "created_at")
+	if !c.QueryBool("include_revoked") {
+		query = query.Where("revoked_at IS NULL")
+	}
+
+	var keys []models.APIKey
+	if err := query.Scan(requestContext(c), &keys); err != nil {
+		return problem.Internal(err, "Failed to fetch API keys")
+	}
+
+	if len(keys) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.APIKey{})
+	}
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// Create a new API key
+func CreateAPIKey(c *fiber.Ctx) error {
+	var requestData struct {
+		Integration string     `json:"integration" validate:"required,max=255"`
+		Scopes      []string   `json:"scopes" validate:"required"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	requestData.Integration = strings.TrimSpace(requestData.Integration)
+	errs := validation.Struct(&requestData)
+	for _, scope := range requestData.Scopes {
+		if !auth.ValidScope(scope) {
+			errs.Add("scopes", validation.OneOf, fmt.Sprintf("Unknown scope '%s'", scope))
+		}
+	}
+	if requestData.ExpiresAt != nil && !requestData.ExpiresAt.After(time.Now()) {
+		errs.Add("expires_at", validation.Invalid, "Expiry must be in the future")
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	key, plain, err := newAPIKey(requestData.Integration, requestData.Scopes, requestData.ExpiresAt, auth.FromContext(c))
+	if err == nil {
+		err = insertAudited(c, audit.APIKey, key)
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     plain,
+		"api_key": key,
+	})
+}
+
+// RotateAPIKey issues a replacement key with the same integration, scopes and
+// expiry. The old key keeps working for the overlap period so clients can switch over.
+func RotateAPIKey(c *fiber.Ctx) error {
+	var requestData struct {
+		Overlap string `json:"overlap"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&requestData); err != nil {
+			slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+			return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+		}
+	}
+
+	overlap := defaultRotationOverlap
+	if requestData.Overlap != "" {
+		parsed, err := time.ParseDuration(requestData.Overlap)
+		if err != nil || parsed < 0 {
+			return validationErrorResponse(c, validationError("overlap", validation.Invalid, "Overlap must be a duration such as \"24h\" or \"0s\""))
+		}
+		overlap = parsed
+	}
+
+	var replacement *models.APIKey
+	var plain string
+	errNotRotatable := errors.New("key cannot be rotated")
+	err := runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		var old models.APIKey
+		err := tx.NewSelect().
+			Model(&old).
+			Where("id = ? AND tenant_id = ?", c.Params("id"), auth.FromContext(c).TenantID).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if old.RevokedAt != nil || old.RotatedToID != nil || (old.ExpiresAt != nil && !now.Before(*old.ExpiresAt)) {
+			return errNotRotatable
+		}
+
+		replacement, plain, err = newAPIKey(old.Integration, old.Scopes, old.ExpiresAt, auth.FromContext(c))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewInsert().Model(replacement).Returning("*").Exec(ctx); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Create, audit.APIKey, replacement.ID, nil, replacement); err != nil {
+			return err
+		}
+
+		before := old
+		overlapEnd := now.Add(overlap)
+		if old.ExpiresAt == nil || overlapEnd.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &overlapEnd
+		}
+		old.RotatedToID = &replacement.ID
+		_, err = tx.NewUpdate().Model(&old).Column("expires_at", "rotated_to_id").WherePK().Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.APIKey, old.ID, &before, &old)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("api_key_not_found", "API key not found")
+	}
+	if errors.Is(err, errNotRotatable) {
+		return problem.Conflict("api_key_cannot_be_rotated", "API key cannot be rotated").WithDetail("The key is revoked, expired or has already been rotated")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to rotate API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"key":     plain,
+		"api_key": replacement,
+	})
+}
+
+// RevokeAPIKey stops a key from working immediately
+func RevokeAPIKey(c *fiber.Ctx) error {
+	err := runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		var key models.APIKey
+		err := tx.NewSelect().
+			Model(&key).
+			Where("id = ? AND tenant_id = ? AND revoked_at IS NULL", c.Params("id"), auth.FromContext(c).TenantID).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		before := key
+		now := time.Now()
+		key.RevokedAt = &now
+		if _, err := tx.NewUpdate().Model(&key).Column("revoked_at").WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.APIKey, key.ID, &before, &key)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("api_key_not_found", "API key not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to revoke API key")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
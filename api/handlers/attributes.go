@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// attributeNamePattern limits attribute names to what can safely be used as a JSON key in queries
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// validateAttributeValue checks a single value against its definition and
+// returns the code and message of the problem, if any
+func validateAttributeValue(definition models.CategoryAttribute, value interface{}) (string, string) {
+	switch definition.Type {
+	case models.AttributeTypeString:
+		text, ok := value.(string)
+		if !ok {
+			return validation.Invalid, fmt.Sprintf("%s must be a string", definition.Name)
+		}
+		if len(definition.AllowedValues) > 0 && !slices.Contains(definition.AllowedValues, text) {
+			return validation.OneOf, fmt.Sprintf("%s must be one of: %s", definition.Name, strings.Join(definition.AllowedValues, ", "))
+		}
+	case models.AttributeTypeNumber:
+		number, ok := value.(float64)
+		if !ok {
+			return validation.Invalid, fmt.Sprintf("%s must be a number", definition.Name)
+		}
+		if len(definition.AllowedValues) > 0 && !slices.Contains(definition.AllowedValues, fmt.Sprint(number)) {
+			return validation.OneOf, fmt.Sprintf("%s must be one of: %s", definition.Name, strings.Join(definition.AllowedValues, ", "))
+		}
+	case models.AttributeTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return validation.Invalid, fmt.Sprintf("%s must be true or false", definition.Name)
+		}
+	}
+	return "", ""
+}
+
+// validateProductAttributes checks attribute values against the definitions of
+// the product's category and returns one error per problem, for fields named
+// attributes.<name>
+func validateProductAttributes(definitions []models.CategoryAttribute, attributes map[string]interface{}) validation.Errors {
+	var problems validation.Errors
+	defined := make(map[string]bool, len(definitions))
+	for _, definition := range definitions {
+		defined[definition.Name] = true
+
+		field := "attributes." + definition.Name
+		value, present := attributes[definition.Name]
+		if !present || value == nil {
+			if definition.Required {
+				problems.Add(field, validation.Required, fmt.Sprintf("%s is required", definition.Name))
+			}
+			continue
+		}
+		if code, message := validateAttributeValue(definition, value); code != "" {
+			problems.Add(field, code, message)
+		}
+	}
+
+	for name := range attributes {
+		if !defined[name] {
+			problems.Add("attributes."+name, validation.Unknown, fmt.Sprintf("%s is not defined for this category", name))
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Field < problems[j].Field
+	})
+
+	return problems
+}
+
+// attributeFilters reads the attr.<name>=<value> query parameters that narrow
+// a product list. Values are compared as text.
+func attributeFilters(c *fiber.Ctx) (map[string]string, error) {
+	var filterErr error
+	filters := map[string]string{}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		name, found := strings.CutPrefix(string(key), "attr.")
+		if !found || filterErr != nil {
+			return
+		}
+		if !attributeNamePattern.MatchString(name) {
+			filterErr = fmt.Errorf("invalid attribute name %q", name)
+			return
+		}
+		filters[name] = string(value)
+	})
+	return filters, filterErr
+}
+
+// parseAttributeRequest reads an attribute definition from the body into attribute
+func parseAttributeRequest(c *fiber.Ctx, attribute *models.CategoryAttribute) error {
+	var requestData struct {
+		Name          string   `json:"name" validate:"required,max=63"`
+		Type          string   `json:"type" validate:"required,oneof=string number boolean"`
+		Unit          string   `json:"unit" validate:"max=50"`
+		Required      bool     `json:"required"`
+		AllowedValues []string `json:"allowed_values"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	errs := validation.Struct(&requestData)
+	if !errs.Has("name") && !attributeNamePattern.MatchString(requestData.Name) {
+		errs.Add("name", validation.Invalid, "name may only contain lowercase letters, digits and underscores")
+	}
+	if requestData.Type == models.AttributeTypeBoolean && len(requestData.AllowedValues) > 0 {
+		errs.Add("allowed_values", validation.Invalid, "Boolean attributes cannot restrict allowed values")
+	}
+	if len(errs) > 0 {
+		return problem.Validation(errs)
+	}
+
+	attribute.Name = requestData.Name
+	attribute.Type = requestData.Type
+	attribute.Unit = requestData.Unit
+	attribute.Required = requestData.Required
+	attribute.AllowedValues = requestData.AllowedValues
+	return nil
+}
+
+// Attributes lists the attributes defined for a category, including inherited ones
+func (h *CategoryHandler) Attributes(c *fiber.Ctx) error {
+	categoryID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_category_id", "Invalid category ID format").WithDetail(err.Error())
+	}
+
+	definitions, err := h.categories.Attributes(requestContext(c), categoryID)
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch attributes")
+	}
+
+	if len(definitions) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.CategoryAttribute{})
+	}
+	return c.Status(fiber.StatusOK).JSON(definitions)
+}
+
+// CreateAttribute defines a new attribute on a category
+func (h *CategoryHandler) CreateAttribute(c *fiber.Ctx) error {
+	categoryID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_category_id", "Invalid category ID format").WithDetail(err.Error())
+	}
+
+	exists, err := h.categories.Exists(requestContext(c), categoryID)
+	if err != nil {
+		return problem.Internal(err, "Failed to create attribute")
+	}
+	if !exists {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+
+	attribute := models.CategoryAttribute{CategoryID: categoryID}
+	if err := parseAttributeRequest(c, &attribute); err != nil {
+		return err
+	}
+
+	// Names must be unique along the whole ancestor chain so inherited
+	// definitions never clash, which includes the chains of the descendants
+	// that will inherit this one
+	inherited, err := h.categories.Attributes(requestContext(c), categoryID)
+	if err != nil {
+		return problem.Internal(err, "Failed to create attribute")
+	}
+	descendants, err := h.categories.SubtreeAttributes(requestContext(c), categoryID)
+	if err != nil {
+		return problem.Internal(err, "Failed to create attribute")
+	}
+	for _, definition := range inherited {
+		if definition.Name == attribute.Name {
+			return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("An attribute named '%s' is already defined for this category", attribute.Name).With("field", "name")
+		}
+	}
+	for _, definition := range descendants {
+		if definition.Name == attribute.Name {
+			return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("An attribute named '%s' is already defined for a subcategory", attribute.Name).With("field", "name")
+		}
+	}
+
+	err = insertAudited(c, audit.CategoryAttribute, &attribute)
+	if err != nil {
+		return problem.Internal(err, "Failed to create attribute")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attribute)
+}
+
+// UpdateAttribute changes an attribute definition. Existing product values are not revalidated.
+func (h *CategoryHandler) UpdateAttribute(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+	attributeID := c.Params("attributeId")
+
+	var attribute models.CategoryAttribute
+	err := tenantDB(c).NewSelect().
+		Model(&attribute).
+		Where("id = ? AND category_id = ?", attributeID, categoryID).
+		Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("attribute_not_found", "Attribute not found")
+	}
+
+	// Renaming would orphan the values already stored on products
+	name := attribute.Name
+	if err := parseAttributeRequest(c, &attribute); err != nil {
+		return err
+	}
+	if attribute.Name != name {
+		return validationErrorResponse(c, validationError("name", validation.ReadOnly, "Attributes cannot be renamed"))
+	}
+
+	err = updateAudited(c, audit.CategoryAttribute, attribute.ID, &attribute)
+	if err != nil {
+		return problem.Internal(err, "Failed to update attribute")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(attribute)
+}
+
+// DeleteAttribute removes an attribute definition
+func (h *CategoryHandler) DeleteAttribute(c *fiber.Ctx) error {
+	err := runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		var attribute models.CategoryAttribute
+		err := tx.NewSelect().
+			Model(&attribute).
+			Where("id = ? AND category_id = ?", c.Params("attributeId"), c.Params("id")).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewDelete().Model(&attribute).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, audit.CategoryAttribute, attribute.ID, &attribute, nil)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("attribute_not_found", "Attribute not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete attribute")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+)
+
+func TestCreateAttributeDuplicateName(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	power := s.category("Power tools", &tools.ID)
+	drills := s.category("Drills", &power.ID)
+	hardware := s.category("Hardware", nil)
+	for _, attribute := range []models.CategoryAttribute{
+		{CategoryID: tools.ID, Name: "brand", Type: models.AttributeTypeString},
+		{CategoryID: drills.ID, Name: "voltage", Type: models.AttributeTypeNumber},
+	} {
+		if err := s.store.DefineAttribute(s.ctx(), attribute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		category *models.Category
+		body     map[string]interface{}
+	}{
+		{"defined on an ancestor", power, map[string]interface{}{"name": "brand", "type": "string"}},
+		{"defined on a descendant", power, map[string]interface{}{"name": "voltage", "type": "number"}},
+		{"defined on the category", drills, map[string]interface{}{"name": "voltage", "type": "number"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var failure problemBody
+			status := s.do(http.MethodPost, "/categories/"+test.category.ID.String()+"/attributes", test.body, &failure)
+			if status != http.StatusConflict || failure.Code != "duplicate_entry" {
+				t.Errorf("answered %d %s, want 409 duplicate_entry", status, failure.Code)
+			}
+		})
+	}
+
+	// Categories outside the subtree do not clash
+	attributes, err := s.repos.Categories.SubtreeAttributes(s.ctx(), hardware.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attributes) != 0 {
+		t.Errorf("unrelated category has attributes %+v in its subtree", attributes)
+	}
+}
+
+func TestMoveAttributeClash(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	power := s.category("Power tools", &tools.ID)
+	drills := s.category("Drills", &power.ID)
+	garden := s.category("Garden", nil)
+	mowers := s.category("Mowers", &garden.ID)
+	for _, attribute := range []models.CategoryAttribute{
+		{CategoryID: tools.ID, Name: "brand", Type: models.AttributeTypeString},
+		{CategoryID: drills.ID, Name: "voltage", Type: models.AttributeTypeNumber},
+		{CategoryID: garden.ID, Name: "voltage", Type: models.AttributeTypeNumber},
+		{CategoryID: mowers.ID, Name: "brand", Type: models.AttributeTypeString},
+	} {
+		if err := s.store.DefineAttribute(s.ctx(), attribute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]interface{}
+	}{
+		{"move defining the name", http.MethodPut, "/categories/" + mowers.ID.String() + "/move", map[string]interface{}{"parent_id": power.ID}},
+		{"move with a subcategory defining the name", http.MethodPut, "/categories/" + power.ID.String() + "/move", map[string]interface{}{"parent_id": garden.ID}},
+		{"patch of the parent", http.MethodPatch, "/categories/" + drills.ID.String(), map[string]interface{}{"ParentID": garden.ID}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var failure problemBody
+			status := s.do(test.method, test.path, test.body, &failure)
+			if status != http.StatusConflict || failure.Code != "duplicate_entry" {
+				t.Errorf("answered %d %s, want 409 duplicate_entry", status, failure.Code)
+			}
+		})
+	}
+
+	category, err := s.repos.Categories.Get(s.ctx(), drills.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.ParentID == nil || *category.ParentID != power.ID {
+		t.Errorf("refused move left the category under %v, want %s", category.ParentID, power.ID)
+	}
+
+	// Moving away from the clashing name is allowed
+	if status := s.do(http.MethodPut, "/categories/"+drills.ID.String()+"/move", map[string]interface{}{"parent_id": tools.ID}, nil); status != http.StatusOK {
+		t.Errorf("move without a clash answered %d, want 200", status)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// withAuditMetadata stores the request's actor in ctx for audit.Record
+func withAuditMetadata(ctx context.Context, c *fiber.Ctx, principal *auth.Principal) context.Context {
+	requestID, _ := c.Locals("requestid").(string)
+	return audit.WithMetadata(ctx, audit.Metadata{
+		ActorKind: principal.Kind,
+		ActorID:   principal.ID,
+		RequestID: requestID,
+		ClientIP:  c.IP(),
+	})
+}
+
+// primaryKey returns the primary key field of a model
+func primaryKey(idb bun.IDB, model interface{}) reflect.Value {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	return idb.Dialect().Tables().Get(v.Type()).PKs[0].Value(v)
+}
+
+// ifMatch checks row versions against the request's If-Match
+func ifMatch(c *fiber.Ctx) repository.Precondition {
+	return func(version int64) error {
+		return checkIfMatch(c, version)
+	}
+}
+
+// insertAudited inserts a model and records its creation in one transaction
+func insertAudited(c *fiber.Ctx, entityType string, model interface{}) error {
+	return runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(model).Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, entityType, audit.EntityID(tx, model), nil, model)
+	})
+}
+
+// updateAudited writes a model over the row with the given ID and records what
+// changed in one transaction. Versioned rows are only written if the request's
+// If-Match allows it. It returns sql.ErrNoRows if there is no such row.
+func updateAudited(c *fiber.Ctx, entityType string, id interface{}, model interface{}) error {
+	return runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		before := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+		err := tx.NewSelect().Model(before).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if version, ok := rowVersion(tx, before); ok {
+			if err := checkIfMatch(c, version); err != nil {
+				return err
+			}
+		}
+		// Request bodies usually leave the ID out; the row keeps its own
+		primaryKey(tx, model).Set(primaryKey(tx, before))
+		// Returning picks up columns the database sets, such as the new version
+		_, err = tx.NewUpdate().Model(model).Where("?TableAlias.id = ?", id).Returning("*").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, entityType, id, before, model)
+	})
+}
+
+// deleteAudited deletes the row with the given ID, loading it into model, and
+// records its last state in one transaction. Models with a soft_delete column
+// are only marked deleted, and versioned rows are checked against If-Match like
+// in updateAudited. It returns sql.ErrNoRows if there is no such row.
+func deleteAudited(c *fiber.Ctx, entityType string, id interface{}, model interface{}) error {
+	return runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(model).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if version, ok := rowVersion(tx, model); ok {
+			if err := checkIfMatch(c, version); err != nil {
+				return err
+			}
+		}
+		// A soft delete sets deleted_at on model; the entry shows the row as it was
+		before := reflect.ValueOf(model).Elem().Interface()
+		if _, err := tx.NewDelete().Model(model).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, entityType, id, before, nil)
+	})
+}
+
+// restoreAudited clears deleted_at on the soft-deleted row with the given ID,
+// loading it into model, and records the restore in one transaction. It
+// returns sql.ErrNoRows if there is no such deleted row.
+func restoreAudited(c *fiber.Ctx, entityType string, id interface{}, model interface{}) error {
+	return runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(model).WhereDeleted().Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		before := reflect.ValueOf(model).Elem().Interface()
+		_, err = tx.NewUpdate().
+			Model(model).
+			WhereDeleted().
+			Set("deleted_at = NULL").
+			WherePK().
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Restore, entityType, id, before, model)
+	})
+}
+
+// auditQuery applies the filters shared by the audit endpoints
+func auditQuery(c *fiber.Ctx, query *bun.SelectQuery) (*bun.SelectQuery, error) {
+	if actorID := c.Query("actor_id"); actorID != "" {
+		if _, err := uuid.Parse(actorID); err != nil {
+			return nil, problem.BadRequest("invalid_actor_id", "Invalid actor ID format").WithDetail(err.Error())
+		}
+		query = query.Where("al.actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("al.action = ?", action)
+	}
+
+	for param, condition := range map[string]string{
+		"from": "al.created_at >= ?",
+		"to":   "al.created_at < ?",
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, problem.BadRequest("invalid_query_parameter", "Invalid query parameter").
+				Detailf("%s must be an RFC 3339 time", param).
+				With("field", param)
+		}
+		query = query.Where(condition, parsed)
+	}
+
+	limit := defaultAuditLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxAuditLimit {
+			return nil, problem.BadRequest("invalid_query_parameter", "Invalid query parameter").
+				Detailf("limit must be between 1 and %d", maxAuditLimit).
+				With("field", "limit")
+		}
+		limit = parsed
+	}
+
+	return query.Order("al.created_at DESC").Limit(limit), nil
+}
+
+// sendAuditEntries runs an audit query and writes the entries, newest first
+func sendAuditEntries(c *fiber.Ctx, query *bun.SelectQuery) error {
+	query, err := auditQuery(c, query)
+	if err != nil {
+		return err
+	}
+
+	var entries []models.AuditEntry
+	if err := query.Scan(requestContext(c), &entries); err != nil {
+		return problem.Internal(err, "Failed to fetch audit log")
+	}
+
+	if len(entries) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.AuditEntry{})
+	}
+	return c.Status(fiber.StatusOK).JSON(entries)
+}
+
+// GetAuditLog lists audit entries, filtered by entity_type, entity_id,
+// actor_id, action and a from/to time range
+func GetAuditLog(c *fiber.Ctx) error {
+	query := tenantDB(c).NewSelect().Model((*models.AuditEntry)(nil))
+	if entityType := c.Query("entity_type"); entityType != "" {
+		query = query.Where("al.entity_type = ?", entityType)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query = query.Where("al.entity_id = ?", entityID)
+	}
+	return sendAuditEntries(c, query)
+}
+
+// GetProductHistory lists the audit entries of one product, including stock movements
+func GetProductHistory(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	query := tenantDB(c).NewSelect().
+		Model((*models.AuditEntry)(nil)).
+		Where("al.entity_type = ? AND al.entity_id = ?", audit.Product, productID.String())
+	return sendAuditEntries(c, query)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// issueTokenPair creates an access token and a tracked refresh token for a user
+func issueTokenPair(ctx context.Context, idb bun.IDB, user *models.User) (fiber.Map, error) {
+	accessToken, _, accessExpiresAt, err := auth.IssueToken(auth.AccessToken, user.ID, user.TenantID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshID, refreshExpiresAt, err := auth.IssueToken(auth.RefreshToken, user.ID, user.TenantID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = idb.NewInsert().Model(&models.RefreshToken{
+		ID:        refreshID,
+		UserID:    user.ID,
+		ExpiresAt: refreshExpiresAt,
+	}).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"access_token":       accessToken,
+		"access_expires_at":  accessExpiresAt,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": refreshExpiresAt,
+		"token_type":         "Bearer",
+		"role":               user.Role,
+		"tenant_id":          user.TenantID,
+	}, nil
+}
+
+// Login exchanges a username and password for an access and refresh token
+func Login(c *fiber.Ctx) error {
+	var requestData struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	var user models.User
+	err := db.NewSelect().Model(&user).Where("LOWER(username) = LOWER(?)", requestData.Username).Scan(requestContext(c))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return problem.Internal(err, "Login failed")
+	}
+	// Unknown users, wrong passwords and disabled accounts get the same
+	// answer, and a password is hashed for each of them, so neither the answer
+	// nor the time it takes tells which usernames exist
+	var valid bool
+	if err != nil || !user.Active {
+		valid = auth.RejectPassword(requestData.Password)
+	} else {
+		valid = auth.CheckPassword(user.PasswordHash, requestData.Password)
+	}
+	if !valid {
+		return problem.Unauthorized("invalid_credentials", "Invalid username or password")
+	}
+
+	tokens, err := issueTokenPair(requestContext(c), db, &user)
+	if err != nil {
+		return problem.Internal(err, "Login failed")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// RefreshTokens rotates a refresh token: the presented token is revoked and a new pair is issued
+func RefreshTokens(c *fiber.Ctx) error {
+	var requestData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	claims, err := auth.ParseToken(requestData.RefreshToken, auth.RefreshToken)
+	if err != nil {
+		return problem.Unauthorized("invalid_refresh_token", "Invalid or expired refresh token")
+	}
+
+	var tokens fiber.Map
+	errRejected := errors.New("refresh token rejected")
+	err = database.RunInTx(requestContext(c), db, nil, func(ctx context.Context, tx bun.Tx) error {
+		var stored models.RefreshToken
+		err := tx.NewSelect().
+			Model(&stored).
+			Relation("User").
+			Where("rt.id = ?", claims.ID).
+			For("UPDATE OF rt").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRejected
+		}
+		if err != nil {
+			return err
+		}
+		if stored.RevokedAt != nil || stored.User == nil || !stored.User.Active {
+			return errRejected
+		}
+
+		now := time.Now()
+		stored.RevokedAt = &now
+		if _, err := tx.NewUpdate().Model(&stored).Column("revoked_at").WherePK().Exec(ctx); err != nil {
+			return err
+		}
+
+		// The role is read again so changes apply from the next refresh
+		tokens, err = issueTokenPair(ctx, tx, stored.User)
+		return err
+	})
+
+	if errors.Is(err, errRejected) {
+		return problem.Unauthorized("invalid_refresh_token", "Invalid or expired refresh token")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to refresh token")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(tokens)
+}
+
+// Logout revokes a refresh token. Access tokens stay valid until they expire.
+func Logout(c *fiber.Ctx) error {
+	var requestData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	claims, err := auth.ParseToken(requestData.RefreshToken, auth.RefreshToken)
+	if err == nil {
+		_, err = db.NewUpdate().
+			Model((*models.RefreshToken)(nil)).
+			Set("revoked_at = ?", time.Now()).
+			Where("id = ? AND revoked_at IS NULL", claims.ID).
+			Exec(requestContext(c))
+		if err != nil {
+			return problem.Internal(err, "Failed to log out")
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// BootstrapAdmin creates a superadmin account in the default tenant from
+// ADMIN_USERNAME and ADMIN_PASSWORD when no users exist yet, so a fresh install
+// can be logged into and further tenants created
+func BootstrapAdmin(ctx context.Context, username, password string) error {
+	count, err := db.NewSelect().Model((*models.User)(nil)).Count(ctx)
+	if err != nil || count > 0 {
+		return err
+	}
+	if username == "" || password == "" {
+		slog.WarnContext(ctx, "No users exist; set ADMIN_USERNAME and ADMIN_PASSWORD to create the first admin")
+		return nil
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.NewInsert().Model(&models.User{
+		ID:           uuid.New(),
+		TenantID:     tenancy.DefaultTenantID,
+		Username:     username,
+		PasswordHash: hash,
+		Role:         auth.RoleSuperadmin,
+		Active:       true,
+	}).Exec(ctx)
+	if err == nil {
+		slog.InfoContext(ctx, "Created admin user", "username", username)
+	}
+	return err
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/patch"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Statuses of the items of a bulk response
+const (
+	bulkCreated         = "created"
+	bulkUpdated         = "updated"
+	bulkDeleted         = "deleted"
+	bulkConflict        = "conflict"
+	bulkValidationError = "validation_error"
+	bulkNotFound        = "not_found"
+	bulkForbidden       = "forbidden"
+	bulkError           = "error"
+	// bulkRolledBack marks operations that succeeded in an atomic batch that failed
+	bulkRolledBack = "rolled_back"
+)
+
+// errBulkFailed rolls back an atomic batch in which an operation failed
+var errBulkFailed = errors.New("bulk operation failed")
+
+// bulkRequest is the body of the bulk endpoints. With Atomic set, every
+// operation is applied in one transaction that is rolled back if any of them
+// fails; otherwise each operation stands on its own.
+type bulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Operations []bulkOperation `json:"operations" validate:"required,max=5000"`
+}
+
+// bulkOperation creates a resource from Data, applies Data as a merge patch to
+// the resource with ID, or deletes it. Version works like If-Match does for
+// the single-resource endpoints.
+type bulkOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Version *int64          `json:"version,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// bulkResult reports the outcome of one operation, in the order of the request
+type bulkResult struct {
+	Index   int         `json:"index"`
+	Status  string      `json:"status"`
+	ID      interface{} `json:"id,omitempty"`
+	Version int64       `json:"version,omitempty"`
+	// Problem says why the operation failed, as the single-resource endpoint would
+	Problem fiber.Map `json:"problem,omitempty"`
+}
+
+// bulkResource describes how the bulk endpoints handle one type of resource
+type bulkResource[T any] struct {
+	name     string
+	create   func(ctx context.Context, row *T) error
+	patch    patchFunc[T]
+	validate patchValidator[T]
+	// delete refuses deletes that the single-resource endpoint would refuse
+	delete           func(ctx context.Context, id uuid.UUID, check repository.Precondition) error
+	deletePermission string
+	// key returns the ID and version of a row
+	key func(row *T) (uuid.UUID, int64)
+	tx  repository.Transactor
+}
+
+// bulkCategoryReassign refuses to delete categories that are not empty
+func bulkCategoryReassign(ctx context.Context, category *models.Category, contents repository.CategoryContents) (*uuid.UUID, error) {
+	return nil, problem.Conflict("category_not_empty", "Category is not empty").
+		Detailf("Category has %d subcategories and %d products; delete it with DELETE /categories/:id?reassign_to=", contents.Children, contents.Products)
+}
+
+// Bulk creates, updates and deletes many products in one request
+func (h *ProductHandler) Bulk(c *fiber.Ctx) error {
+	return runBulk(c, bulkResource[models.Products]{
+		name:             "Product",
+		create:           h.products.Create,
+		patch:            h.products.Patch,
+		validate:         h.validate(c),
+		delete:           h.products.Delete,
+		deletePermission: auth.ProductsDelete,
+		key:              func(product *models.Products) (uuid.UUID, int64) { return product.ID, product.Version },
+		tx:               h.tx,
+	})
+}
+
+// Bulk creates, updates and deletes many categories in one request
+func (h *CategoryHandler) Bulk(c *fiber.Ctx) error {
+	return runBulk(c, bulkResource[models.Category]{
+		name:     "Category",
+		create:   h.categories.Create,
+		patch:    h.categories.Patch,
+		validate: h.validate,
+		delete: func(ctx context.Context, id uuid.UUID, check repository.Precondition) error {
+			return h.categories.Delete(ctx, id, check, bulkCategoryReassign)
+		},
+		deletePermission: auth.CategoriesDelete,
+		key:              func(category *models.Category) (uuid.UUID, int64) { return category.ID, category.Version },
+		tx:               h.tx,
+	})
+}
+
+// Bulk creates, updates and deletes many suppliers in one request
+func (h *SupplierHandler) Bulk(c *fiber.Ctx) error {
+	return runBulk(c, bulkResource[models.Supplier]{
+		name:     "Supplier",
+		create:   h.suppliers.Create,
+		patch:    h.suppliers.Patch,
+		validate: validateSupplier,
+		delete: func(ctx context.Context, id uuid.UUID, check repository.Precondition) error {
+			// Like Delete, refuses suppliers that still have products
+			products, err := h.products.Count(ctx, repository.ProductFilter{SupplierID: id})
+			if err != nil {
+				return err
+			}
+			if products > 0 {
+				return problem.Conflict("supplier_has_products", "Supplier has products").
+					Detailf("Supplier still supplies %d products; assign them to another supplier first", products)
+			}
+			return h.suppliers.Delete(ctx, id, check)
+		},
+		deletePermission: auth.SuppliersDelete,
+		key:              func(supplier *models.Supplier) (uuid.UUID, int64) { return supplier.ID, supplier.Version },
+		tx:               h.tx,
+	})
+}
+
+// runBulk applies the operations of a bulk request and answers with one result
+// per operation. Each operation runs in its own transaction, or in a savepoint
+// of the batch's transaction when the batch is atomic, so every operation is
+// tried and reported even after one has failed.
+func runBulk[T any](c *fiber.Ctx, resource bulkResource[T]) error {
+	var request bulkRequest
+	if err := c.BodyParser(&request); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+	if errs := validation.Struct(&request); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	results := make([]bulkResult, len(request.Operations))
+	failed := 0
+	// applyAll may run more than once, as transactions are retried
+	applyAll := func(ctx context.Context) error {
+		failed = 0
+		for i, operation := range request.Operations {
+			results[i] = bulkResult{Index: i}
+			err := resource.tx.InTx(ctx, func(ctx context.Context) error {
+				// A retried operation starts over
+				results[i] = bulkResult{Index: i}
+				return applyBulkOperation(ctx, c, resource, operation, &results[i])
+			})
+			if request.Atomic && errors.Is(err, database.ErrSerialization) {
+				// The batch's transaction is aborted and is retried as a whole
+				return err
+			}
+			if err != nil {
+				failed++
+				setBulkError(c, &results[i], err, resource.name)
+			}
+		}
+		return nil
+	}
+
+	status := fiber.StatusOK
+	if request.Atomic {
+		err := resource.tx.InTx(requestContext(c), func(ctx context.Context) error {
+			if err := applyAll(ctx); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return errBulkFailed
+			}
+			return nil
+		})
+		if failure := databaseErrorResponse(err, "Batch"); failure != nil {
+			return failure
+		}
+		if err != nil && !errors.Is(err, errBulkFailed) {
+			return problem.Internal(err, "Bulk operation failed")
+		}
+		if failed > 0 {
+			// Nothing was written, including the operations that went through,
+			// so created resources have no ID to report
+			for i, operation := range request.Operations {
+				if results[i].Problem != nil {
+					continue
+				}
+				rolledBack := bulkResult{Index: i, Status: bulkRolledBack}
+				if operation.Op != "create" {
+					rolledBack.ID = results[i].ID
+				}
+				results[i] = rolledBack
+			}
+			status = fiber.StatusUnprocessableEntity
+		}
+	} else {
+		// Without a batch transaction every failure ends up in the results
+		applyAll(requestContext(c))
+	}
+
+	succeeded := len(results) - failed
+	if request.Atomic && failed > 0 {
+		succeeded = 0
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"atomic":    request.Atomic,
+		"succeeded": succeeded,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+// applyBulkOperation applies one operation and fills in result
+func applyBulkOperation[T any](ctx context.Context, c *fiber.Ctx, resource bulkResource[T], operation bulkOperation, result *bulkResult) error {
+	switch operation.Op {
+	case "create", "update", "delete":
+	default:
+		return validationError("op", validation.OneOf, "op must be one of create, update, delete")
+	}
+
+	if operation.Op == "create" {
+		if len(operation.Data) == 0 {
+			return validationError("data", validation.Required, "data is required")
+		}
+		row := new(T)
+		if err := json.Unmarshal(operation.Data, row); err != nil {
+			return validationError("data", validation.Invalid, err.Error())
+		}
+		// The database assigns these, as it does for the create endpoints
+		v := reflect.ValueOf(row).Elem()
+		for _, name := range []string{"ID", "Version", "DeletedAt"} {
+			if field := v.FieldByName(name); field.IsValid() {
+				field.SetZero()
+			}
+		}
+		// A nil before tells the validator the resource is new
+		if err := resource.validate(ctx, nil, row); err != nil {
+			return err
+		}
+		if err := resource.create(ctx, row); err != nil {
+			return err
+		}
+		result.Status = bulkCreated
+		result.ID, result.Version = resource.key(row)
+		return nil
+	}
+
+	id, err := uuid.Parse(operation.ID)
+	if err != nil {
+		return validationError("id", validation.UUID, "id must be a UUID")
+	}
+	result.ID = id
+	check := expectVersion(operation.Version)
+
+	if operation.Op == "update" {
+		if len(operation.Data) == 0 {
+			return validationError("data", validation.Required, "data is required")
+		}
+		row, err := resource.patch(ctx, id, check, patchChange(patch.MergePatchType, operation.Data, resource.validate))
+		if err != nil {
+			return err
+		}
+		result.Status = bulkUpdated
+		_, result.Version = resource.key(row)
+		return nil
+	}
+
+	if !auth.FromContext(c).Can(resource.deletePermission) {
+		return &permissionError{permission: resource.deletePermission}
+	}
+	if err := resource.delete(ctx, id, check); err != nil {
+		return err
+	}
+	result.Status = bulkDeleted
+	return nil
+}
+
+// expectVersion checks row versions against the version an operation names,
+// which stands in for If-Match
+func expectVersion(expected *int64) repository.Precondition {
+	return func(version int64) error {
+		if expected == nil {
+			if RequireIfMatch {
+				return errPreconditionRequired
+			}
+			return nil
+		}
+		if *expected != version {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// setBulkError records why an operation failed, as patchErrorResponse would
+// answer for the single-resource endpoints
+func setBulkError(c *fiber.Ctx, result *bulkResult, err error, name string) {
+	var failure *problem.Problem
+	if errors.Is(err, errPreconditionRequired) {
+		// There are no headers per operation, so the version goes in the body
+		failure = problem.Validation(validation.Errors{{Field: "version", Code: validation.Required, Message: "version is required; send the version the change is based on"}})
+	} else {
+		failure = problem.From(patchErrorResponse(c, err, name))
+	}
+
+	result.Version = 0
+	switch failure.Status {
+	case fiber.StatusNotFound:
+		result.Status = bulkNotFound
+	case fiber.StatusBadRequest, fiber.StatusUnprocessableEntity:
+		result.Status = bulkValidationError
+	case fiber.StatusConflict, fiber.StatusPreconditionFailed:
+		result.Status = bulkConflict
+	case fiber.StatusForbidden:
+		result.Status = bulkForbidden
+	default:
+		slog.ErrorContext(c.UserContext(), "Bulk operation failed", "error", err)
+		result.Status = bulkError
+		failure = problem.Internal(err, "Operation failed")
+	}
+	result.Problem = failure.Document()
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/google/uuid"
+)
+
+// bulkResponse is the body of the bulk endpoints
+type bulkResponse struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Results   []struct {
+		Status  string      `json:"status"`
+		Problem problemBody `json:"problem"`
+	} `json:"results"`
+}
+
+// bulk sends operations to path and returns the response
+func (s *testServer) bulk(path string, atomic bool, operations ...map[string]interface{}) (int, bulkResponse) {
+	s.t.Helper()
+	var response bulkResponse
+	status := s.do(http.MethodPost, path, map[string]interface{}{"atomic": atomic, "operations": operations}, &response)
+	return status, response
+}
+
+// statuses lists the status of each result of response
+func statuses(response bulkResponse) []string {
+	list := make([]string, len(response.Results))
+	for i, result := range response.Results {
+		list[i] = result.Status
+	}
+	return list
+}
+
+func TestBulkAtomicRollsBack(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+
+	status, response := s.bulk("/categories/bulk", true,
+		map[string]interface{}{"op": "create", "data": map[string]interface{}{"Name": "Saws"}},
+		map[string]interface{}{"op": "update", "id": tools.ID, "data": map[string]interface{}{"Name": "Hand tools"}},
+		map[string]interface{}{"op": "delete", "id": uuid.New()},
+	)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("failed batch answered %d, want 422", status)
+	}
+	want := []string{bulkRolledBack, bulkRolledBack, bulkNotFound}
+	if got := statuses(response); !slices.Equal(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+
+	categories, err := s.repos.Categories.List(s.ctx(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 1 || categories[0].Name != "Tools" || categories[0].Version != tools.Version {
+		t.Errorf("rolled back batch left %+v", categories)
+	}
+}
+
+func TestBulkIndependentOperations(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	s.category("Saws", &tools.ID)
+
+	status, response := s.bulk("/categories/bulk", false,
+		map[string]interface{}{"op": "create", "data": map[string]interface{}{"Name": "Hardware"}},
+		map[string]interface{}{"op": "delete", "id": tools.ID},
+		map[string]interface{}{"op": "update", "id": tools.ID, "data": map[string]interface{}{"ID": uuid.New()}},
+		map[string]interface{}{"op": "update", "id": tools.ID, "data": map[string]interface{}{"parent_id": uuid.New()}},
+	)
+	if status != http.StatusOK {
+		t.Fatalf("batch answered %d, want 200", status)
+	}
+	if response.Succeeded != 1 || response.Failed != 3 {
+		t.Errorf("%d operations succeeded and %d failed, want 1 and 3", response.Succeeded, response.Failed)
+	}
+	want := []string{bulkCreated, bulkConflict, bulkValidationError, bulkValidationError}
+	if got := statuses(response); !slices.Equal(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+	if code := response.Results[1].Problem.Code; code != "category_not_empty" {
+		t.Errorf("deleting a category with contents failed with %s, want category_not_empty", code)
+	}
+	if errs := response.Results[3].Problem.Errors; len(errs) != 1 || errs[0].Field != "parent_id" || errs[0].Code != validation.Unknown {
+		t.Errorf("updating an unknown field failed with %+v, want an unknown error on parent_id", errs)
+	}
+
+	categories, err := s.repos.Categories.List(s.ctx(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 3 {
+		t.Errorf("batch left %d categories, want 3", len(categories))
+	}
+}
+
+func TestBulkSupplierWithProducts(t *testing.T) {
+	s := newTestServer(t)
+	acme := s.supplier("Acme")
+	s.product("Hammer", s.category("Tools", nil).ID, acme.ID)
+
+	_, response := s.bulk("/suppliers/bulk", false, map[string]interface{}{"op": "delete", "id": acme.ID})
+	if len(response.Results) != 1 || response.Results[0].Problem.Code != "supplier_has_products" {
+		t.Errorf("deleting a supplier with products gave %+v, want supplier_has_products", response.Results)
+	}
+	if _, err := s.repos.Suppliers.Get(s.ctx(), acme.ID); err != nil {
+		t.Errorf("supplier is gone: %v", err)
+	}
+}
@@ -1,183 +1,360 @@
-package handlers
-
-import (
-	"fmt"
-	"log"
-	"strings"
-
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
-	"github.com/gofiber/fiber/v2"
-)
-
-// Get all categories
-func GetAllCategories(c *fiber.Ctx) error {
-	var categories []models.Category
-	err = db.NewSelect().Model(&categories).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch categories",
-		})
-	}
-
-	if len(categories) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]models.Category{})
-	}
-	return c.Status(fiber.StatusOK).JSON(categories)
-}
-
-// Create a new category
-func CreateCategory(c *fiber.Ctx) error {
-	var category models.Category
-	if err := c.BodyParser(&category); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
-	}
-
-	// Validate required fields
-	if category.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Validation failed",
-			"details": "Category name is required and cannot be empty",
-		})
-	}
-
-	// Check if category with same name already exists
-	var existingCategory models.Category
-	err := db.NewSelect().
-		Model(&existingCategory).
-		Where("LOWER(name) = LOWER(?)", category.Name).
-		Scan(dbCtx)
-	
-	if err == nil {
-		// Category with this name already exists
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "Duplicate entry",
-			"details": fmt.Sprintf("A category with the name '%s' already exists", category.Name),
-			"field": "name",
-		})
-	}
-
-	_, err = db.NewInsert().Model(&category).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Full Database Error: %+v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Database operation failed",
-			"details": "Failed to create category. Please try again later",
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(category)
-}
-
-// Get a single category by ID
-func GetOneCategory(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var category models.Category
-
-	err = db.NewSelect().Model(&category).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Category not found",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(category)
-}
-
-// Update a category
-func UpdateCategory(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var originalCategory models.Category
-
-	// Check if category exists
-	err = db.NewSelect().Model(&originalCategory).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Category not found",
-		})
-	}
-
-	var category models.Category
-	if err := c.BodyParser(&category); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
-	}
-
-	// Validate required fields
-	if category.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Validation failed",
-			"details": "Category name is required",
-		})
-	}
-
-	// Preserve the ID from the original category
-	category.ID = originalCategory.ID
-
-	// Perform the update
-	_, err = db.NewUpdate().Model(&category).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		// Check for unique constraint violations
-		if strings.Contains(strings.ToLower(err.Error()), "unique constraint") || 
-		   strings.Contains(strings.ToLower(err.Error()), "duplicate key") {
-			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-				"error": "Duplicate entry",
-				"details": fmt.Sprintf("A category with the name '%s' already exists", category.Name),
-				"field": "name",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update category",
-			"details": "Database operation failed",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(category)
-}
-
-// Delete a category
-func DeleteCategory(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-
-	result, err := db.NewDelete().Model((*models.Category)(nil)).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete category",
-		})
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Category not found",
-		})
-	}
-
-	return c.SendStatus(fiber.StatusNoContent)
-}
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// CategoryHandler serves the category endpoints from a CategoryRepository.
+// The attribute definitions are written with bun, in the transaction of the
+// request.
+type CategoryHandler struct {
+	categories repository.CategoryRepository
+	tx         repository.Transactor
+}
+
+// NewCategoryHandler returns a CategoryHandler that uses repos
+func NewCategoryHandler(repos repository.Repositories) *CategoryHandler {
+	return &CategoryHandler{categories: repos.Categories, tx: repos.Tx}
+}
+
+// checkParent verifies that parentID exists and is not inside the subtree of
+// the category being moved, which would create a cycle
+func (h *CategoryHandler) checkParent(ctx context.Context, id uuid.UUID, parentID *uuid.UUID) error {
+	return repository.CheckParent(ctx, h.categories, id, parentID)
+}
+
+// parentNotFound reports a parent_id that names no category
+var parentNotFound = validationError("parent_id", validation.NotFound, "Parent category does not exist")
+
+// categoryParentErrorResponse maps errors from checkParent to a response
+func categoryParentErrorResponse(c *fiber.Ctx, err error) error {
+	var clash *repository.AttributeClashError
+	switch {
+	case errors.Is(err, repository.ErrParentNotFound):
+		return validationErrorResponse(c, parentNotFound)
+	case errors.Is(err, repository.ErrCategoryCycle):
+		return problem.Conflict("invalid_move", "Invalid move").WithDetail("A category cannot be moved beneath itself or one of its descendants").With("field", "parent_id")
+	case errors.As(err, &clash):
+		return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("An attribute named '%s' is defined both for the new parent or its ancestors and for this category or its subcategories", clash.Name).With("field", "parent_id")
+	}
+
+	return problem.Internal(err, "Database operation failed")
+}
+
+// List returns all categories
+func (h *CategoryHandler) List(c *fiber.Ctx) error {
+	withDeleted, err := includeDeleted(c, auth.CategoriesDelete)
+	if err != nil {
+		return err
+	}
+	categories, err := h.categories.List(requestContext(c), withDeleted)
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch categories")
+	}
+
+	if len(categories) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.Category{})
+	}
+	return c.Status(fiber.StatusOK).JSON(categories)
+}
+
+// Create a new category
+func (h *CategoryHandler) Create(c *fiber.Ctx) error {
+	var category models.Category
+	if err := c.BodyParser(&category); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	if errs := validation.Struct(&category); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	if err := h.checkParent(requestContext(c), uuid.Nil, category.ParentID); err != nil {
+		return categoryParentErrorResponse(c, err)
+	}
+
+	// The unique index on names refuses duplicates
+	err := h.categories.Create(requestContext(c), &category)
+	if failure := databaseErrorResponse(err, "Category"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to create category")
+	}
+
+	return sendVersioned(c, fiber.StatusCreated, category.Version, category)
+}
+
+// Get a single category by ID
+func (h *CategoryHandler) Get(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+
+	category, err := h.categories.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, category.Version, category)
+}
+
+// Update a category
+func (h *CategoryHandler) Update(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+
+	// Check if category exists
+	originalCategory, err := h.categories.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+
+	var category models.Category
+	if err := c.BodyParser(&category); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	if errs := validation.Struct(&category); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// Preserve the ID and position from the original category, moves go through Move
+	category.ID = originalCategory.ID
+	category.ParentID = originalCategory.ParentID
+
+	// Perform the update
+	err = h.categories.Update(requestContext(c), &category, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if failure := databaseErrorResponse(err, "Category"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to update category")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, category.Version, category)
+}
+
+// Patch changes only the fields named in a merge patch or JSON Patch. A
+// changed ParentID is checked like in Move, attribute names included.
+func (h *CategoryHandler) Patch(c *fiber.Ctx) error {
+	category, err := patchResource(c, h.categories.Patch, h.validate)
+	if err != nil {
+		return patchErrorResponse(c, err, "Category")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, category.Version, category)
+}
+
+// validate checks a patched or new category. Duplicate names are left to the
+// unique index, like in Create.
+func (h *CategoryHandler) validate(ctx context.Context, original, patched *models.Category) error {
+	if errs := validation.Struct(patched); len(errs) > 0 {
+		return errs
+	}
+	var err error
+	if original == nil {
+		err = h.checkParent(ctx, uuid.Nil, patched.ParentID)
+	} else if !sameParent(patched.ParentID, original.ParentID) {
+		err = h.checkParent(ctx, original.ID, patched.ParentID)
+	}
+	if errors.Is(err, repository.ErrParentNotFound) {
+		return parentNotFound
+	}
+	return err
+}
+
+// sameParent reports whether two parent IDs name the same category, or both
+// the root
+func sameParent(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Tree returns all categories nested under their parents
+func (h *CategoryHandler) Tree(c *fiber.Ctx) error {
+	categories, err := h.categories.List(requestContext(c), false)
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch categories")
+	}
+
+	nodes := make(map[uuid.UUID]*models.CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &models.CategoryNode{
+			Category: category,
+			Children: []*models.CategoryNode{},
+		}
+	}
+
+	roots := []*models.CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if category.ParentID == nil || nodes[*category.ParentID] == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent := nodes[*category.ParentID]
+		parent.Children = append(parent.Children, node)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(roots)
+}
+
+// Move moves a category, together with its subtree, under a new parent
+func (h *CategoryHandler) Move(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_category_id", "Invalid category ID format").WithDetail(err.Error())
+	}
+
+	// A null or missing parent_id moves the category to the root
+	var requestData struct {
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	category, err := h.categories.Move(requestContext(c), id, requestData.ParentID, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if failure := databaseErrorResponse(err, "Category"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return categoryParentErrorResponse(c, err)
+	}
+
+	return sendVersioned(c, fiber.StatusOK, category.Version, category)
+}
+
+// Delete a category. Categories that still have children or products are only
+// deleted when ?reassign_to= names where they should go: another category's ID,
+// or "parent" to move them one level up. The category is only marked deleted
+// and can be restored until the purge job removes it.
+func (h *CategoryHandler) Delete(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_category_id", "Invalid category ID format").WithDetail(err.Error())
+	}
+	reassignTo := c.Query("reassign_to")
+
+	err = h.categories.Delete(requestContext(c), id, ifMatch(c), func(ctx context.Context, category *models.Category, contents repository.CategoryContents) (*uuid.UUID, error) {
+		var target *uuid.UUID
+		switch reassignTo {
+		case "":
+			return nil, problem.Conflict("category_not_empty", "Category is not empty").
+				Detailf("Category has %d subcategories and %d products; pass reassign_to to move them", contents.Children, contents.Products).
+				With("children", contents.Children).
+				With("products", contents.Products)
+		case "parent":
+			target = category.ParentID
+		default:
+			parsed, err := uuid.Parse(reassignTo)
+			if err != nil {
+				return nil, problem.BadRequest("invalid_reassign_to", "Invalid reassign_to value").WithDetail("Expected a category ID or \"parent\"")
+			}
+			// Rules out the category itself and its descendants as the target
+			if err := h.checkParent(ctx, id, &parsed); err != nil {
+				return nil, err
+			}
+			target = &parsed
+		}
+
+		if contents.Products > 0 && target == nil {
+			return nil, problem.Conflict("category_not_empty", "Category is not empty").
+				WithDetail("Products of a top-level category must be reassigned to a category ID").
+				With("products", contents.Products)
+		}
+		return target, nil
+	})
+
+	// The category cannot be deleted as requested
+	var refusal *problem.Problem
+	if errors.As(err, &refusal) {
+		return refusal
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("category_not_found", "Category not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	var clash *repository.AttributeClashError
+	if errors.As(err, &clash) {
+		return categoryParentErrorResponse(c, err)
+	}
+	if errors.Is(err, repository.ErrParentNotFound) || errors.Is(err, repository.ErrCategoryCycle) {
+		return problem.BadRequest("invalid_reassign_to", "Invalid reassign_to value").WithDetail("Target category must exist and lie outside the deleted category")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete category")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Restore undoes the deletion of a category. Its parent must exist, so a
+// deleted subtree is restored from the top down. Children and products that
+// were reassigned on delete stay where they were moved.
+func (h *CategoryHandler) Restore(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("deleted_category_not_found", "Deleted category not found")
+	}
+
+	category, err := h.categories.GetDeleted(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("deleted_category_not_found", "Deleted category not found")
+	}
+
+	if err := h.checkParent(requestContext(c), uuid.Nil, category.ParentID); err != nil {
+		return categoryParentErrorResponse(c, err)
+	}
+
+	category, err = h.categories.Restore(requestContext(c), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("deleted_category_not_found", "Deleted category not found")
+	}
+	// Another category may have taken the name in the meantime
+	if failure := databaseErrorResponse(err, "Category"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to restore category")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, category.Version, category)
+}
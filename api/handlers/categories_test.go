@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/google/uuid"
+)
+
+func TestCategoryPatch(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	path := "/categories/" + tools.ID.String()
+
+	var patched models.Category
+	if status := s.do(http.MethodPatch, path, map[string]interface{}{"Name": "Hand tools"}, &patched); status != http.StatusOK {
+		t.Fatalf("patch answered %d, want 200", status)
+	}
+	if patched.Name != "Hand tools" || patched.Version != tools.Version+1 {
+		t.Errorf("patched to %q version %d, want %q version %d", patched.Name, patched.Version, "Hand tools", tools.Version+1)
+	}
+
+	var failure problemBody
+	if status := s.do(http.MethodPatch, path, map[string]interface{}{"ID": uuid.New()}, &failure); status != http.StatusUnprocessableEntity {
+		t.Fatalf("patching the ID answered %d, want 422", status)
+	}
+	if len(failure.Errors) != 1 || failure.Errors[0].Field != "id" {
+		t.Errorf("patching the ID failed with %+v, want an error on id", failure.Errors)
+	}
+
+	failure = problemBody{}
+	if status := s.do(http.MethodPatch, path, map[string]interface{}{"parent": "Hardware"}, &failure); status != http.StatusUnprocessableEntity {
+		t.Fatalf("patching an unknown field answered %d, want 422", status)
+	}
+	if len(failure.Errors) != 1 || failure.Errors[0].Field != "parent" || failure.Errors[0].Code != validation.Unknown {
+		t.Errorf("patching an unknown field failed with %+v, want an unknown error on parent", failure.Errors)
+	}
+
+	if status := s.do(http.MethodPatch, "/categories/"+uuid.NewString(), map[string]interface{}{"Name": "Gone"}, nil); status != http.StatusNotFound {
+		t.Errorf("patching a missing category answered %d, want 404", status)
+	}
+}
+
+func TestCategoryMove(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	saws := s.category("Saws", &tools.ID)
+
+	var failure problemBody
+	status := s.do(http.MethodPut, "/categories/"+tools.ID.String()+"/move", map[string]interface{}{"parent_id": saws.ID}, &failure)
+	if status != http.StatusConflict || failure.Code != "invalid_move" {
+		t.Errorf("moving a category under its child answered %d %s, want 409 invalid_move", status, failure.Code)
+	}
+
+	var moved models.Category
+	if status := s.do(http.MethodPut, "/categories/"+saws.ID.String()+"/move", map[string]interface{}{"parent_id": nil}, &moved); status != http.StatusOK {
+		t.Fatalf("moving to the root answered %d, want 200", status)
+	}
+	if moved.ParentID != nil {
+		t.Errorf("moved category has parent %s, want none", moved.ParentID)
+	}
+}
+
+func TestCategoryDelete(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	saws := s.category("Saws", &tools.ID)
+	hardware := s.category("Hardware", nil)
+	hammer := s.product("Hammer", tools.ID, s.supplier("Acme").ID)
+	path := "/categories/" + tools.ID.String()
+
+	var failure problemBody
+	if status := s.do(http.MethodDelete, path, nil, &failure); status != http.StatusConflict || failure.Code != "category_not_empty" {
+		t.Errorf("deleting a category with contents answered %d %s, want 409 category_not_empty", status, failure.Code)
+	}
+	// Products cannot move up from a top-level category
+	if status := s.do(http.MethodDelete, path+"?reassign_to=parent", nil, nil); status != http.StatusConflict {
+		t.Errorf("reassigning products to no parent answered %d, want 409", status)
+	}
+	if status := s.do(http.MethodDelete, path+"?reassign_to="+saws.ID.String(), nil, nil); status != http.StatusBadRequest {
+		t.Errorf("reassigning to a child answered %d, want 400", status)
+	}
+
+	if status := s.do(http.MethodDelete, path+"?reassign_to="+hardware.ID.String(), nil, nil); status != http.StatusNoContent {
+		t.Fatalf("deleting with reassign_to answered %d, want 204", status)
+	}
+	if status := s.do(http.MethodGet, path, nil, nil); status != http.StatusNotFound {
+		t.Errorf("deleted category answered %d, want 404", status)
+	}
+	moved, err := s.repos.Categories.Get(s.ctx(), saws.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ParentID == nil || *moved.ParentID != hardware.ID {
+		t.Errorf("subcategory has parent %v, want %s", moved.ParentID, hardware.ID)
+	}
+	product, err := s.repos.Products.Get(s.ctx(), hammer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.CategoryID != hardware.ID {
+		t.Errorf("product is in category %s, want %s", product.CategoryID, hardware.ID)
+	}
+}
+
+func TestCategoryTenantIsolation(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	path := "/categories/" + tools.ID.String()
+	other := s.tokenFor(uuid.New())
+
+	requests := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodGet, path, nil},
+		{http.MethodPatch, path, map[string]interface{}{"Name": "Taken"}},
+		{http.MethodPut, path + "/move", map[string]interface{}{"parent_id": nil}},
+		{http.MethodDelete, path, nil},
+	}
+	for _, r := range requests {
+		if status := s.request(other, r.method, r.path, r.body, nil); status != http.StatusNotFound {
+			t.Errorf("%s %s by another tenant answered %d, want 404", r.method, r.path, status)
+		}
+	}
+
+	category, err := s.repos.Categories.Get(s.ctx(), tools.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.Name != "Tools" || category.Version != tools.Version {
+		t.Errorf("another tenant changed the category to %q version %d", category.Name, category.Version)
+	}
+}
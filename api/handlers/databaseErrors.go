@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+)
+
+// uniqueFields names the field each unique index is about, for reporting
+// which field of a request is a duplicate
+var uniqueFields = map[string]string{
+	"categories_name_key": "name",
+	"suppliers_name_key":  "name",
+	"suppliers_email_key": "email",
+}
+
+// databaseErrorResponse maps the database errors a request can cause, such as
+// a duplicate or a conflict with a concurrent transaction, to a response. It
+// returns nil for other errors, which are internal. name is the resource as
+// it appears in messages, such as "Category".
+func databaseErrorResponse(err error, name string) *problem.Problem {
+	var dbErr *database.Error
+	if !errors.As(database.Translate(err), &dbErr) {
+		return nil
+	}
+	resource := strings.ToLower(name)
+
+	switch dbErr.Kind {
+	case database.ErrDuplicate:
+		field, ok := uniqueFields[dbErr.Constraint]
+		if !ok {
+			return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("The %s repeats one that already exists", resource)
+		}
+		return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("A %s with this %s already exists", resource, field).With("field", field)
+	case database.ErrForeignKey:
+		return problem.Conflict("reference_violation", "Reference violation").Detailf("The %s refers to a row that does not exist, or is still referred to", resource)
+	case database.ErrCheck:
+		return problem.Unprocessable("check_violation", "Check violation").Detailf("The %s breaks the rule %s", resource, dbErr.Constraint)
+	case database.ErrSerialization:
+		return problem.Conflict("concurrent_update", "Concurrent update").WithDetail("The request conflicted with a concurrent one; retry it")
+	}
+	return nil
+}
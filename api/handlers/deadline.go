@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/uptrace/bun"
+)
+
+// RequestTimeout is how long a request may take unless its route allows more
+var RequestTimeout = 30 * time.Second
+
+// LongRequestTimeout is how long the bulk and upload endpoints may take
+var LongRequestTimeout = 2 * time.Minute
+
+// baseContextKey is the fiber.Ctx Locals key Deadline keeps the request's
+// context without a deadline under
+const baseContextKey = "baseContext"
+
+// Deadline cancels the request's context, and with it the queries run on it,
+// once timeout has passed. Queries still running then fail, and the request is
+// answered with 504. A Deadline further down a route replaces the one before
+// it, so routes can be given more or less time than the rest of the app.
+//
+// The deadline is set on the request's user context, so canceling the context
+// the app sets there cancels the request as well. fasthttp does not report
+// clients that disconnect mid-request, so for those the deadline is what stops
+// the work.
+func Deadline(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		base, ok := c.Locals(baseContextKey).(context.Context)
+		if !ok {
+			base = c.UserContext()
+			c.Locals(baseContextKey, base)
+		}
+		ctx, cancel := context.WithTimeout(base, timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		// A tenant connection taken before this deadline still has the old one
+		if conn, ok := c.Locals(tenantConnKey).(*bun.Conn); ok {
+			if err := limitStatements(ctx, conn); err != nil {
+				return err
+			}
+		}
+		return c.Next()
+	}
+}
+
+// limitStatements has Postgres cancel the statements run on conn at ctx's
+// deadline, so it stops working on requests nobody waits for any more.
+// Without a deadline the server's default applies.
+func limitStatements(ctx context.Context, conn bun.IDB) error {
+	timeout, ok := database.StatementTimeout(ctx)
+	if !ok {
+		return nil
+	}
+	_, err := conn.ExecContext(ctx, "SELECT set_config('statement_timeout', ?, false)", timeout)
+	return err
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/uptrace/bun"
+)
+
+// RequireIfMatch makes writes to versioned resources fail with 428 unless they
+// send If-Match. Without it, writes that leave If-Match out are not checked.
+var RequireIfMatch = true
+
+var (
+	errPreconditionRequired = errors.New("if-match required")
+	errPreconditionFailed   = errors.New("if-match does not match")
+)
+
+// etag returns the entity tag of a row version
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// rowVersion returns the version column of a model, if it has one
+func rowVersion(idb bun.IDB, model interface{}) (int64, bool) {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	field, ok := idb.Dialect().Tables().Get(v.Type()).FieldMap["version"]
+	if !ok {
+		return 0, false
+	}
+	return field.Value(v).Int(), true
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists the
+// tag. If-Match compares strongly, so weak tags only count for If-None-Match.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendVersioned writes a versioned resource with its ETag. A GET whose
+// If-None-Match already names that version gets 304 Not Modified instead.
+func sendVersioned(c *fiber.Ctx, status int, version int64, body interface{}) error {
+	tag := etag(version)
+	c.Set(fiber.HeaderETag, tag)
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" && etagMatches(noneMatch, tag, true) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+	}
+	return c.Status(status).JSON(body)
+}
+
+// checkIfMatch compares the request's If-Match with the version of the row
+// about to be written. It must run in the transaction that writes the row,
+// after the row has been locked.
+func checkIfMatch(c *fiber.Ctx, version int64) error {
+	ifMatch := c.Get(fiber.HeaderIfMatch)
+	if ifMatch == "" {
+		if RequireIfMatch {
+			return errPreconditionRequired
+		}
+		return nil
+	}
+	if !etagMatches(ifMatch, etag(version), false) {
+		// Tells the client which version it lost to
+		c.Set(fiber.HeaderETag, etag(version))
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// isPreconditionError reports whether err came from checkIfMatch
+func isPreconditionError(err error) bool {
+	return errors.Is(err, errPreconditionRequired) || errors.Is(err, errPreconditionFailed)
+}
+
+// preconditionErrorResponse maps errors from checkIfMatch to a response
+func preconditionErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errPreconditionRequired) {
+		return problem.New(fiber.StatusPreconditionRequired, "precondition_required", "Precondition required").WithDetail("Send the resource's ETag in If-Match")
+	}
+	return problem.New(fiber.StatusPreconditionFailed, "precondition_failed", "Precondition failed").WithDetail("The resource has changed since it was read; fetch it again and retry")
+}
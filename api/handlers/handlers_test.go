@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/migrations"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// testServer serves the product, category and supplier endpoints from
+// in-memory repositories. Its requests are made by an admin of tenant.
+type testServer struct {
+	t      *testing.T
+	app    *fiber.App
+	store  *repository.Memory
+	repos  repository.Repositories
+	tenant uuid.UUID
+	token  string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	auth.Configure(auth.TokenConfig{Secret: []byte("handler-tests"), AccessTTL: time.Hour})
+	requireIfMatch := RequireIfMatch
+	RequireIfMatch = false
+	t.Cleanup(func() { RequireIfMatch = requireIfMatch })
+
+	store := repository.NewMemory()
+	repos := store.Repositories()
+	products := NewProductHandler(repos)
+	categories := NewCategoryHandler(repos)
+	suppliers := NewSupplierHandler(repos)
+
+	// Routed as in main, without TenantScope, which needs a database; the
+	// repositories limit queries to the principal's tenant themselves
+	app := fiber.New(fiber.Config{ErrorHandler: problem.Handler})
+	app.Post("/products/bulk", auth.Authenticate, products.Bulk)
+	app.Put("/products/:id", auth.Authenticate, products.Update)
+	app.Patch("/products/:id", auth.Authenticate, products.Patch)
+	app.Post("/categories", auth.Authenticate, categories.Create)
+	app.Post("/categories/bulk", auth.Authenticate, categories.Bulk)
+	app.Get("/categories/:id", auth.Authenticate, categories.Get)
+	app.Put("/categories/:id", auth.Authenticate, categories.Update)
+	app.Patch("/categories/:id", auth.Authenticate, categories.Patch)
+	app.Delete("/categories/:id", auth.Authenticate, categories.Delete)
+	app.Put("/categories/:id/move", auth.Authenticate, categories.Move)
+	app.Post("/categories/:id/attributes", auth.Authenticate, categories.CreateAttribute)
+	app.Post("/suppliers/bulk", auth.Authenticate, suppliers.Bulk)
+	app.Put("/suppliers/:id", auth.Authenticate, suppliers.Update)
+
+	s := &testServer{t: t, app: app, store: store, repos: repos, tenant: uuid.New()}
+	s.token = s.tokenFor(s.tenant)
+	return s
+}
+
+// tokenFor returns an access token for an admin of tenantID
+func (s *testServer) tokenFor(tenantID uuid.UUID) string {
+	s.t.Helper()
+	token, _, _, err := auth.IssueToken(auth.AccessToken, uuid.New(), tenantID, auth.RoleAdmin)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return token
+}
+
+// ctx returns a context limited to the server's tenant, for seeding and
+// inspecting the repositories
+func (s *testServer) ctx() context.Context {
+	return tenancy.WithTenant(context.Background(), s.tenant)
+}
+
+// request sends body as JSON with token and decodes the JSON response into out,
+// if it is not nil. It returns the response status.
+func (s *testServer) request(token, method, path string, body, out interface{}) int {
+	s.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			s.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// do is request with the server's admin token
+func (s *testServer) do(method, path string, body, out interface{}) int {
+	s.t.Helper()
+	return s.request(s.token, method, path, body, out)
+}
+
+// category stores a category named name under parentID
+func (s *testServer) category(name string, parentID *uuid.UUID) *models.Category {
+	s.t.Helper()
+	category := &models.Category{Name: name, ParentID: parentID}
+	if err := s.repos.Categories.Create(s.ctx(), category); err != nil {
+		s.t.Fatal(err)
+	}
+	return category
+}
+
+// supplier stores a supplier named name
+func (s *testServer) supplier(name string) *models.Supplier {
+	s.t.Helper()
+	supplier := &models.Supplier{Name: name}
+	if err := s.repos.Suppliers.Create(s.ctx(), supplier); err != nil {
+		s.t.Fatal(err)
+	}
+	return supplier
+}
+
+// product stores a product in categoryID from supplierID
+func (s *testServer) product(name string, categoryID, supplierID uuid.UUID) *models.Products {
+	s.t.Helper()
+	product := &models.Products{Name: name, CategoryID: categoryID, SupplierID: supplierID, BaseUnit: "each"}
+	if err := s.repos.Products.Create(s.ctx(), product); err != nil {
+		s.t.Fatal(err)
+	}
+	return product
+}
+
+// problemBody is the part of a problem response the tests look at
+type problemBody struct {
+	Code   string `json:"code"`
+	Errors []struct {
+		Field string `json:"field"`
+		Code  string `json:"code"`
+	} `json:"errors"`
+}
+
+// testDatabaseEnv names the Postgres database the tests that need one migrate
+// and write to
+const testDatabaseEnv = "TEST_DATABASE_URL"
+
+// dbServer serves the endpoints whose handlers query the database directly,
+// routed through TenantScope as in main. Its requests are made by an admin of
+// a tenant it creates.
+type dbServer struct {
+	*testServer
+	db *bun.DB
+}
+
+func newDBServer(t *testing.T) *dbServer {
+	t.Helper()
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+	auth.Configure(auth.TokenConfig{Secret: []byte("handler-tests"), AccessTTL: time.Hour})
+
+	pool, err := database.ConnectDb(dsn, database.PoolConfig{MaxOpenConns: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	ctx := context.Background()
+	migrator := migrations.NewMigrator(pool)
+	if err := migrator.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = migrator.Migrate(ctx)
+	migrator.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDB(pool)
+	t.Cleanup(func() { SetDB(nil) })
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.Handler})
+	orders := app.Group("/orders", auth.Authenticate, TenantScope)
+	orders.Get("/:id/items", auth.Require(auth.SalesWrite), GetAllOrderItems)
+	orders.Post("/:id/items", auth.Require(auth.SalesWrite), CreateOrderItem)
+	orders.Get("/:id/items/:itemId", auth.Require(auth.SalesWrite), GetOneOrderItem)
+	orders.Put("/:id/items/:itemId", auth.Require(auth.SalesWrite), UpdateOrderItem)
+	orders.Delete("/:id/items/:itemId", auth.Require(auth.SalesWrite), DeleteOrderItem)
+	purchaseOrders := app.Group("/purchase-orders", auth.Authenticate, TenantScope)
+	purchaseOrders.Post("/", auth.Require(auth.StockWrite), CreatePurchaseOrder)
+	purchaseOrders.Get("/:id", auth.Require(auth.StockWrite), GetPurchaseOrder)
+	purchaseOrders.Post("/:id/receive", auth.Require(auth.StockWrite), ReceivePurchaseOrder)
+	purchaseOrders.Post("/:id/cancel", auth.Require(auth.StockWrite), CancelPurchaseOrder)
+	app.Get("/uploads/*", auth.Authenticate, TenantScope, auth.Require(auth.ProductsRead), GetStoredImage)
+
+	tenantID := uuid.New()
+	_, err = pool.ExecContext(ctx, "INSERT INTO tenants (id, name, slug) VALUES (?, ?, ?)",
+		tenantID, "Test "+tenantID.String(), "test-"+tenantID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dbServer{testServer: &testServer{t: t, app: app, tenant: tenantID}, db: pool}
+	s.token = s.tokenFor(tenantID)
+	t.Cleanup(func() {
+		// In the order of their foreign keys
+		s.exec("DELETE FROM purchase_order_items")
+		s.exec("DELETE FROM purchase_orders")
+		s.exec("DELETE FROM order_items")
+		s.exec("DELETE FROM orders")
+		s.exec("DELETE FROM product_units")
+		s.exec("DELETE FROM product_images")
+		s.exec("DELETE FROM products")
+		s.exec("DELETE FROM suppliers")
+		s.exec("DELETE FROM categories")
+		s.exec("DELETE FROM audit_log")
+		pool.ExecContext(ctx, "DELETE FROM tenants WHERE id = ?", tenantID)
+	})
+	return s
+}
+
+// conn returns a connection limited to the server's tenant, as TenantScope
+// sets one up
+func (s *dbServer) conn(ctx context.Context) (bun.Conn, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return conn, err
+	}
+	_, err = conn.ExecContext(ctx, "SELECT set_config(?, ?, false)", tenancy.SettingName, s.tenant.String())
+	if err != nil {
+		conn.Close()
+	}
+	return conn, err
+}
+
+// exec runs query as the server's tenant and returns the ID of the row it
+// returns, if any. Errors are ignored, as they are during cleanup.
+func (s *dbServer) exec(query string, args ...interface{}) uuid.UUID {
+	ctx := context.Background()
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return uuid.Nil
+	}
+	defer conn.Close()
+	var id uuid.UUID
+	conn.QueryRowContext(ctx, query, args...).Scan(&id)
+	return id
+}
+
+// seed runs query as the server's tenant and returns the ID it returns
+func (s *dbServer) seed(query string, args ...interface{}) uuid.UUID {
+	s.t.Helper()
+	id := s.exec(query, args...)
+	if id == uuid.Nil {
+		s.t.Fatalf("seeding with %q returned no ID", query)
+	}
+	return id
+}
+
+// stocked seeds a product with quantity in stock, whose base unit is each and
+// which is also stocked in cases of 24
+func (s *dbServer) stocked(quantity float64) (productID, supplierID uuid.UUID) {
+	s.t.Helper()
+	categoryID := s.seed("INSERT INTO categories (name) VALUES ('Tools') RETURNING id")
+	supplierID = s.seed("INSERT INTO suppliers (name) VALUES ('Acme') RETURNING id")
+	productID = s.seed(`INSERT INTO products (name, category_id, supplier_id, price, quantity)
+		VALUES ('Nails', ?, ?, 1, ?) RETURNING id`, categoryID, supplierID, quantity)
+	s.seed("INSERT INTO product_units (product_id, name, factor) VALUES (?, 'case', 24) RETURNING id", productID)
+	return productID, supplierID
+}
+
+// stock returns the quantity of a product in stock
+func (s *dbServer) stock(productID uuid.UUID) float64 {
+	s.t.Helper()
+	ctx := context.Background()
+	conn, err := s.conn(ctx)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer conn.Close()
+	var quantity float64
+	if err := conn.QueryRowContext(ctx, "SELECT quantity FROM products WHERE id = ?", productID).Scan(&quantity); err != nil {
+		s.t.Fatal(err)
+	}
+	return quantity
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/migrations"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"github.com/gofiber/fiber/v2"
+)
+
+// readinessTimeout bounds the checks of Readyz, so a hanging database makes
+// the instance unready rather than the probe time out
+const readinessTimeout = 2 * time.Second
+
+// draining is set once the server is shutting down
+var draining atomic.Bool
+
+// StartDraining makes Readyz fail from now on, so load balancers stop sending
+// requests to an instance that is about to shut down
+func StartDraining() {
+	draining.Store(true)
+}
+
+// Healthz answers as long as the process can serve requests at all
+func Healthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+}
+
+// Readyz answers 200 when the instance can take requests: it is not shutting
+// down, the database answers and the schema has no pending migrations.
+// Otherwise it answers 503 with the checks that failed.
+func Readyz(c *fiber.Ctx) error {
+	if draining.Load() {
+		return problem.Unavailable("not_ready", "Not ready").WithDetail("The server is shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), readinessTimeout)
+	defer cancel()
+
+	checks := fiber.Map{"database": "ok", "migrations": "ok"}
+	ready := true
+	if err := db.PingContext(ctx); err != nil {
+		slog.WarnContext(c.UserContext(), "Readiness check failed", "check", "database", "error", err)
+		checks["database"] = "unreachable"
+		checks["migrations"] = "unknown"
+		ready = false
+	} else if pending, err := migrations.Pending(ctx, db); err != nil {
+		slog.WarnContext(c.UserContext(), "Readiness check failed", "check", "migrations", "error", err)
+		checks["migrations"] = "unknown"
+		ready = false
+	} else if len(pending) > 0 {
+		checks["migrations"] = "pending " + pending.String()
+		ready = false
+	}
+
+	if !ready {
+		return problem.Unavailable("not_ready", "Not ready").WithDetail("A readiness check failed").With("checks", checks)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ready", "checks": checks})
+}
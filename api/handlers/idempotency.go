@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// IdempotencyTTL is how long a stored response is replayed for its key
+var IdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength keeps keys to the size of a UUID or similar token
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with a response and sent again on replay
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderETag, fiber.HeaderLocation}
+
+// Idempotent makes a POST safe to retry. When the request carries an
+// Idempotency-Key, the first response with that key is stored, and a retry
+// with the same key and body gets that response again without running the
+// handler. Reusing a key for a different request fails with 422. It must run
+// after auth.Authenticate and TenantScope.
+//
+// A key is claimed until the request's deadline. If the request fails, the
+// claim is released so the client can retry; if the server dies before that,
+// the claim lapses at the deadline and a retry takes the key over.
+func Idempotent(c *fiber.Ctx) error {
+	key := c.Get("Idempotency-Key")
+	if key == "" {
+		return c.Next()
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return problem.BadRequest("invalid_idempotency_key", "Invalid Idempotency-Key").WithDetail("Keys can be at most 255 characters")
+	}
+
+	ctx := requestContext(c)
+	idb := tenantDB(c)
+
+	sum := sha256.New()
+	sum.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	sum.Write(c.Body())
+	now := time.Now()
+	claimedUntil := now.Add(RequestTimeout)
+	if deadline, ok := ctx.Deadline(); ok {
+		claimedUntil = deadline
+	}
+	record := models.IdempotencyKey{
+		ID:           uuid.New(),
+		ActorID:      auth.FromContext(c).ID,
+		Key:          key,
+		Fingerprint:  hex.EncodeToString(sum.Sum(nil)),
+		ClaimedUntil: claimedUntil,
+		ExpiresAt:    now.Add(IdempotencyTTL),
+	}
+
+	// An expired key is free to be used again, as is one whose request
+	// outlived its claim without storing a response
+	_, err := idb.NewDelete().
+		Model((*models.IdempotencyKey)(nil)).
+		Where("actor_id = ? AND key = ?", record.ActorID, key).
+		WhereGroup(" AND ", func(q *bun.DeleteQuery) *bun.DeleteQuery {
+			return q.Where("expires_at < ?", now).WhereOr("status = 0 AND claimed_until < ?", now)
+		}).
+		Exec(ctx)
+	if err != nil {
+		return problem.Internal(err, "Failed to check Idempotency-Key")
+	}
+
+	result, err := idb.NewInsert().
+		Model(&record).
+		On("CONFLICT (actor_id, key) DO NOTHING").
+		Returning("NULL").
+		Exec(ctx)
+	var claimed int64
+	if err == nil {
+		claimed, err = result.RowsAffected()
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to check Idempotency-Key")
+	}
+
+	if claimed == 0 {
+		return replayIdempotent(c, record)
+	}
+
+	// The key is released or its response stored even if the request ran out
+	// of time. Failures are not stored, so the client can retry with the same
+	// key; that includes a handler that panics.
+	ctx = context.WithoutCancel(ctx)
+	release := true
+	defer func() {
+		if !release {
+			return
+		}
+		// A retry may have taken over the key after the claim lapsed, so only
+		// this request's row is deleted
+		if _, err := idb.NewDelete().Model(&record).WherePK().Exec(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to release Idempotency-Key", "error", err)
+		}
+	}()
+
+	// Errors are turned into their response here rather than after the
+	// middleware returns, so that the response can be stored
+	if err = c.Next(); err != nil {
+		err = c.App().ErrorHandler(c, err)
+	}
+	status := c.Response().StatusCode()
+	if err != nil || status >= fiber.StatusInternalServerError {
+		return err
+	}
+	release = false
+
+	record.Status = status
+	record.Body = append([]byte(nil), c.Response().Body()...)
+	record.Headers = map[string]string{}
+	for _, header := range replayedHeaders {
+		if value := c.GetRespHeader(header); value != "" {
+			record.Headers[header] = value
+		}
+	}
+	_, err = idb.NewUpdate().Model(&record).Column("status", "headers", "body").WherePK().Exec(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to store response for Idempotency-Key", "error", err)
+	}
+	return nil
+}
+
+// replayIdempotent answers a request whose key has been used before
+func replayIdempotent(c *fiber.Ctx, request models.IdempotencyKey) error {
+	var stored models.IdempotencyKey
+	err := tenantDB(c).NewSelect().
+		Model(&stored).
+		Where("actor_id = ? AND key = ?", request.ActorID, request.Key).
+		Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to check Idempotency-Key")
+	}
+
+	if stored.Fingerprint != request.Fingerprint {
+		return problem.Unprocessable("idempotency_key_reused", "Idempotency-Key reused").WithDetail("This key was already used for a different request")
+	}
+	if stored.Status == 0 {
+		return problem.Conflict("request_in_progress", "Request in progress").WithDetail("A request with this Idempotency-Key is still being processed")
+	}
+
+	for header, value := range stored.Headers {
+		c.Set(header, value)
+	}
+	c.Set("Idempotent-Replayed", "true")
+	return c.Status(stored.Status).Send(stored.Body)
+}
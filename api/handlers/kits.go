@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+var (
+	errKitNotFound       = errors.New("kit not found")
+	errKitHasNoBOM       = errors.New("kit has no components")
+	errInsufficientStock = errors.New("insufficient stock")
+	errNestedKit         = errors.New("nested kit")
+	errComponentNotFound = errors.New("component not found")
+)
+
+// kitQuantityRequest is the body accepted by the assemble, disassemble and sell endpoints
+type kitQuantityRequest struct {
+	Quantity int `json:"quantity" validate:"gt=0"`
+}
+
+// loadKitComponents returns the bill of materials of a kit with component
+// products loaded. With forUpdate, the component products are locked for the
+// rest of the transaction before their stock is read.
+func loadKitComponents(ctx context.Context, idb bun.IDB, kitID uuid.UUID, forUpdate bool) ([]models.KitComponent, error) {
+	if forUpdate {
+		if err := lockKitComponents(ctx, idb, kitID); err != nil {
+			return nil, err
+		}
+	}
+
+	var components []models.KitComponent
+	err := idb.NewSelect().
+		Model(&components).
+		Relation("Component").
+		Where("kc.kit_id = ?", kitID).
+		OrderExpr("kc.component_id").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return components, nil
+}
+
+// lockKitComponents locks the component products of a kit. Postgres refuses to
+// lock rows on the nullable side of the outer join that loads the components,
+// so they are locked with a query of their own, in ID order so that requests
+// assembling kits with shared components cannot deadlock.
+func lockKitComponents(ctx context.Context, idb bun.IDB, kitID uuid.UUID) error {
+	componentIDs := idb.NewSelect().
+		Model((*models.KitComponent)(nil)).
+		Column("component_id").
+		Where("kc.kit_id = ?", kitID)
+
+	var locked []uuid.UUID
+	return idb.NewSelect().
+		Model((*models.Products)(nil)).
+		Column("id").
+		Where("id IN (?)", componentIDs).
+		Order("id").
+		For("UPDATE").
+		Scan(ctx, &locked)
+}
+
+// buildableQuantity is the number of kits that can be assembled from current component stock
+func buildableQuantity(components []models.KitComponent) int {
+	if len(components) == 0 {
+		return 0
+	}
+
+	buildable := -1
+	for _, component := range components {
+		if component.Quantity <= 0 || component.Component == nil {
+			return 0
+		}
+		n := int(math.Floor(component.Component.Quantity / component.Quantity))
+		if buildable == -1 || n < buildable {
+			buildable = n
+		}
+	}
+	if buildable < 0 {
+		return 0
+	}
+	return buildable
+}
+
+// adjustStock adds delta (which may be negative) to a product's quantity and
+// records the movement in the audit log
+func adjustStock(ctx context.Context, idb bun.IDB, productID uuid.UUID, delta float64) error {
+	var before float64
+	err := idb.NewSelect().
+		Model((*models.Products)(nil)).
+		Column("quantity").
+		Where("id = ?", productID).
+		For("UPDATE").
+		Scan(ctx, &before)
+	if err != nil {
+		return err
+	}
+
+	after := before + delta
+	_, err = idb.NewUpdate().
+		Model((*models.Products)(nil)).
+		Set("quantity = ?", after).
+		Where("id = ?", productID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, idb, audit.Update, audit.Product, productID,
+		fiber.Map{"quantity": before}, fiber.Map{"quantity": after})
+}
+
+// bomSnapshot describes a bill of materials for the audit log
+func bomSnapshot(components []models.KitComponent) fiber.Map {
+	quantities := make(map[string]float64, len(components))
+	for _, component := range components {
+		quantities[component.ComponentID.String()] = component.Quantity
+	}
+	return fiber.Map{"components": quantities}
+}
+
+// lockKit loads a kit product row and locks it for the rest of the transaction
+func lockKit(ctx context.Context, tx bun.Tx, kitID uuid.UUID) (*models.Products, error) {
+	var kit models.Products
+	err := tx.NewSelect().
+		Model(&kit).
+		Where("id = ?", kitID).
+		For("UPDATE").
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errKitNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kit, nil
+}
+
+// consumeComponents takes the components for quantity kits out of stock
+func consumeComponents(ctx context.Context, tx bun.Tx, components []models.KitComponent, quantity int) error {
+	if len(components) == 0 {
+		return errKitHasNoBOM
+	}
+	if buildableQuantity(components) < quantity {
+		return errInsufficientStock
+	}
+	for _, component := range components {
+		if err := adjustStock(ctx, tx, component.ComponentID, -component.Quantity*float64(quantity)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kitErrorResponse maps errors returned from kit transactions to a response
+func kitErrorResponse(c *fiber.Ctx, err error, action string) error {
+	switch {
+	case errors.Is(err, errKitNotFound):
+		return problem.NotFound("kit_not_found", "Kit not found")
+	case errors.Is(err, errKitHasNoBOM):
+		return problem.Unprocessable("not_a_kit", "Product is not a kit").WithDetail("No components are defined for this product")
+	case errors.Is(err, errInsufficientStock):
+		return problem.Conflict("insufficient_stock", "Insufficient stock").WithDetail(err.Error())
+	}
+
+	return problem.Internal(err, fmt.Sprintf("Failed to %s kit", action))
+}
+
+// parseKitRequest reads the kit ID from the URL and the quantity from the body
+func parseKitRequest(c *fiber.Ctx) (uuid.UUID, int, error) {
+	kitID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, 0, problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var requestData kitQuantityRequest
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return uuid.Nil, 0, problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	if errs := validation.Struct(&requestData); len(errs) > 0 {
+		return uuid.Nil, 0, problem.Validation(errs)
+	}
+
+	return kitID, requestData.Quantity, nil
+}
+
+// GetKitComponents returns the bill of materials of a kit and how many can be built
+func GetKitComponents(c *fiber.Ctx) error {
+	kitID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var kit models.Products
+	err = tenantDB(c).NewSelect().Model(&kit).Where("id = ?", kitID).Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	components, err := loadKitComponents(requestContext(c), tenantDB(c), kitID, false)
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch kit components")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"kit_id":     kit.ID,
+		"assembled":  kit.Quantity,
+		"buildable":  buildableQuantity(components),
+		"components": components,
+	})
+}
+
+// SetKitComponents replaces the bill of materials of a kit
+func SetKitComponents(c *fiber.Ctx) error {
+	kitID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var requestData []struct {
+		ComponentID string  `json:"component_id" validate:"required"`
+		Quantity    float64 `json:"quantity" validate:"gt=0"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	components := make([]models.KitComponent, 0, len(requestData))
+	seen := make(map[uuid.UUID]bool)
+	var errs validation.Errors
+	for i, item := range requestData {
+		prefix := fmt.Sprintf("[%d].", i)
+		for _, fieldError := range validation.Struct(&item) {
+			errs.Add(prefix+fieldError.Field, fieldError.Code, fieldError.Message)
+		}
+		if errs.Has(prefix + "component_id") {
+			continue
+		}
+		componentID, err := uuid.Parse(item.ComponentID)
+		switch {
+		case err != nil:
+			errs.Add(prefix+"component_id", validation.UUID, "component_id must be a UUID")
+			continue
+		case componentID == kitID:
+			errs.Add(prefix+"component_id", validation.Invalid, "A kit cannot contain itself")
+			continue
+		case seen[componentID]:
+			errs.Add(prefix+"component_id", validation.Invalid, fmt.Sprintf("Component %s is listed more than once", componentID))
+			continue
+		}
+		seen[componentID] = true
+		components = append(components, models.KitComponent{
+			KitID:       kitID,
+			ComponentID: componentID,
+			Quantity:    item.Quantity,
+		})
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := lockKit(ctx, tx, kitID); err != nil {
+			return err
+		}
+
+		if len(components) > 0 {
+			found, err := tx.NewSelect().
+				Model((*models.Products)(nil)).
+				Where("id IN (?)", bun.In(keys(seen))).
+				Apply(tenancy.Scope(ctx)).
+				Count(ctx)
+			if err != nil {
+				return err
+			}
+			if found != len(components) {
+				return errComponentNotFound
+			}
+
+			// Components that are kits themselves would allow cycles in the BOM
+			nested, err := tx.NewSelect().
+				Model((*models.KitComponent)(nil)).
+				Where("kit_id IN (?)", bun.In(keys(seen))).
+				Apply(tenancy.Scope(ctx)).
+				Count(ctx)
+			if err != nil {
+				return err
+			}
+			if nested > 0 {
+				return errNestedKit
+			}
+		}
+
+		isComponent, err := tx.NewSelect().
+			Model((*models.KitComponent)(nil)).
+			Where("component_id = ?", kitID).
+			Apply(tenancy.Scope(ctx)).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if isComponent && len(components) > 0 {
+			return errNestedKit
+		}
+
+		var previous []models.KitComponent
+		_, err = tx.NewDelete().
+			Model(&previous).
+			Where("kit_id = ?", kitID).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(components) > 0 {
+			if _, err := tx.NewInsert().Model(&components).Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.Product, kitID, bomSnapshot(previous), bomSnapshot(components))
+	})
+
+	if errors.Is(err, errComponentNotFound) {
+		return validationErrorResponse(c, validationError("component_id", validation.NotFound, "One or more component products do not exist"))
+	}
+	if errors.Is(err, errNestedKit) {
+		return validationErrorResponse(c, validationError("component_id", validation.Invalid, "Kits cannot be nested inside other kits"))
+	}
+	if err != nil {
+		return kitErrorResponse(c, err, "update")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(components)
+}
+
+// keys returns the IDs in a set
+func keys(set map[uuid.UUID]bool) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// AssembleKit builds kits from component stock
+func AssembleKit(c *fiber.Ctx) error {
+	kitID, quantity, err := parseKitRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var kit *models.Products
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		locked, err := lockKit(ctx, tx, kitID)
+		if err != nil {
+			return err
+		}
+		kit = locked
+
+		components, err := loadKitComponents(ctx, tx, kitID, true)
+		if err != nil {
+			return err
+		}
+		if err := consumeComponents(ctx, tx, components, quantity); err != nil {
+			return err
+		}
+
+		kit.Quantity += float64(quantity)
+		return adjustStock(ctx, tx, kitID, float64(quantity))
+	})
+	if err != nil {
+		return kitErrorResponse(c, err, "assemble")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"kit_id":    kitID,
+		"assembled": quantity,
+		"quantity":  kit.Quantity,
+	})
+}
+
+// DisassembleKit breaks assembled kits back into component stock
+func DisassembleKit(c *fiber.Ctx) error {
+	kitID, quantity, err := parseKitRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var kit *models.Products
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		locked, err := lockKit(ctx, tx, kitID)
+		if err != nil {
+			return err
+		}
+		kit = locked
+		if kit.Quantity < float64(quantity) {
+			return fmt.Errorf("%w: only %g assembled", errInsufficientStock, kit.Quantity)
+		}
+
+		components, err := loadKitComponents(ctx, tx, kitID, true)
+		if err != nil {
+			return err
+		}
+		if len(components) == 0 {
+			return errKitHasNoBOM
+		}
+
+		for _, component := range components {
+			if err := adjustStock(ctx, tx, component.ComponentID, component.Quantity*float64(quantity)); err != nil {
+				return err
+			}
+		}
+
+		kit.Quantity -= float64(quantity)
+		return adjustStock(ctx, tx, kitID, -float64(quantity))
+	})
+	if err != nil {
+		return kitErrorResponse(c, err, "disassemble")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"kit_id":       kitID,
+		"disassembled": quantity,
+		"quantity":     kit.Quantity,
+	})
+}
+
+// SellKit takes sold kits out of stock. Pre-assembled kits are used first and
+// any remainder is fulfilled by consuming components directly.
+func SellKit(c *fiber.Ctx) error {
+	kitID, quantity, err := parseKitRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var fromAssembled, fromComponents int
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		kit, err := lockKit(ctx, tx, kitID)
+		if err != nil {
+			return err
+		}
+
+		fromAssembled = min(int(math.Floor(max(kit.Quantity, 0))), quantity)
+		fromComponents = quantity - fromAssembled
+
+		if fromAssembled > 0 {
+			if err := adjustStock(ctx, tx, kitID, -float64(fromAssembled)); err != nil {
+				return err
+			}
+		}
+		if fromComponents == 0 {
+			return nil
+		}
+
+		components, err := loadKitComponents(ctx, tx, kitID, true)
+		if err != nil {
+			return err
+		}
+		return consumeComponents(ctx, tx, components, fromComponents)
+	})
+	if err != nil {
+		return kitErrorResponse(c, err, "sell")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"kit_id":          kitID,
+		"sold":            quantity,
+		"from_assembled":  fromAssembled,
+		"from_components": fromComponents,
+	})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/logging"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/metrics"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"github.com/google/uuid"
+)
+
+// RunKPIJob recomputes the stock and order metrics every interval until ctx
+// is done. The figures are kept between runs, so scrapes do not query the
+// database.
+func RunKPIJob(ctx context.Context, interval time.Duration) {
+	ctx = logging.With(ctx, slog.String("job", "metrics"))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		kpis, err := CollectKPIs(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to compute stock and order metrics", "error", err)
+		}
+		// Without the list of tenants the last figures are kept
+		if kpis != nil {
+			metrics.SetKPIs(kpis)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CollectKPIs returns the stock and order figures of every tenant, keyed by
+// tenant slug. Tenants whose figures cannot be read are left out.
+func CollectKPIs(ctx context.Context) (map[string]metrics.KPIs, error) {
+	var tenants []models.Tenant
+	if err := db.NewSelect().Model(&tenants).Scan(ctx); err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]metrics.KPIs, len(tenants))
+	var errs []error
+	for _, tenant := range tenants {
+		kpis, err := tenantKPIs(ctx, tenant.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.Slug, err))
+			continue
+		}
+		all[tenant.Slug] = kpis
+	}
+	return all, errors.Join(errs...)
+}
+
+// tenantKPIs reads one tenant's figures on a connection scoped to the tenant,
+// so row-level security lets the job see its rows
+func tenantKPIs(ctx context.Context, tenantID uuid.UUID) (metrics.KPIs, error) {
+	kpis := metrics.KPIs{OrdersByStatus: map[string]int{}}
+	conn, err := tenantConn(ctx, tenantID)
+	if err != nil {
+		return kpis, err
+	}
+	defer releaseTenantConn(ctx, conn)
+
+	// Raw queries bypass the model hooks, so the tenant and deleted rows are
+	// filtered explicitly
+	err = conn.NewRaw(`
+		SELECT
+			COALESCE(SUM(price * quantity), 0),
+			COUNT(*) FILTER (WHERE reorder_point > 0 AND quantity <= reorder_point)
+		FROM products
+		WHERE tenant_id = ? AND deleted_at IS NULL`, tenantID).
+		Scan(ctx, &kpis.StockValue, &kpis.ProductsBelowReorderPoint)
+	if err != nil {
+		return kpis, err
+	}
+
+	var statuses []struct {
+		Status string
+		Count  int
+	}
+	err = conn.NewRaw(`SELECT status, COUNT(*) AS count FROM orders WHERE tenant_id = ? GROUP BY status`, tenantID).
+		Scan(ctx, &statuses)
+	if err != nil {
+		return kpis, err
+	}
+	for _, status := range statuses {
+		kpis.OrdersByStatus[status.Status] = status.Count
+	}
+	return kpis, nil
+}
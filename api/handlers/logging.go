@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/logging"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// logLookupError logs why a record could not be loaded before a handler
+// answers 404. Missing rows are routine; anything else is an error the
+// client does not get to see.
+func logLookupError(ctx context.Context, err error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		slog.DebugContext(ctx, "Record not found", "error", err)
+		return
+	}
+	slog.ErrorContext(ctx, "Failed to load record", "error", err)
+}
+
+// GetLogLevel returns the lowest level logged
+func GetLogLevel(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"level": logging.Level.Level()})
+}
+
+// SetLogLevel changes the lowest level logged until the server restarts, for
+// example to debug a problem without a redeploy
+func SetLogLevel(c *fiber.Ctx) error {
+	var requestData struct {
+		Level string `json:"level"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(requestData.Level)); err != nil {
+		return problem.Unprocessable("invalid_log_level", "Invalid log level").
+			WithDetail("level must be debug, info, warn or error")
+	}
+	previous := logging.Level.Level()
+	logging.Level.Set(level)
+	slog.InfoContext(c.UserContext(), "Log level changed", "from", previous, "to", level)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"level": level})
+}
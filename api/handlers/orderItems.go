@@ -1,142 +1,183 @@
-package handlers
-
-import (
-	"log"
-
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
-	"github.com/gofiber/fiber/v2"
-)
-
-func GetAllOrderItems(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-	
-	_, err := db.NewCreateTable().Model(&models.OrderItem{}).IfNotExists().Exec(dbCtx)
-	
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "An unexpected error occurred",
-		})
-	}
-
-	var orderItems []models.OrderItem
-	err = db.NewSelect().Model(&orderItems).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	if len(orderItems) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]models.OrderItem{})
-	}
-	return c.Status(fiber.StatusOK).JSON(orderItems)
-}
-
-func CreateOrderItem(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	var orderItem models.OrderItem
-	if err := c.BodyParser(&orderItem); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	_, err = db.NewInsert().Model(&orderItem).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create order item",
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(orderItem)
-}
-
-func GetOneOrderItem(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var orderItem models.OrderItem
-
-	err = db.NewSelect().Model(&orderItem).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order item not found",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(orderItem)
-}
-
-func UpdateOrderItem(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var orderItem models.OrderItem
-
-	err = db.NewSelect().Model(&orderItem).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order item not found",
-		})
-	}
-
-	if err := c.BodyParser(&orderItem); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	_, err = db.NewUpdate().Model(&orderItem).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update order item",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(orderItem)
-}
-
-func DeleteOrderItem(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-
-	result, err := db.NewDelete().Model((*models.OrderItem)(nil)).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete order item",
-		})
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order item not found",
-		})
-	}
-
-	return c.SendStatus(fiber.StatusNoContent)
-}
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// errProductNotFound is returned for items naming a product that does not exist
+var errProductNotFound = errors.New("product not found")
+
+// setBaseQuantity converts a quantity given in unit for the product with
+// productID into the product's base unit
+func setBaseQuantity(ctx context.Context, idb bun.IDB, productID uuid.UUID, unit string, quantity float64, base *float64) error {
+	var product models.Products
+	err := idb.NewSelect().Model(&product).Where("id = ?", productID).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	*base, err = toBaseUnit(ctx, idb, &product, unit, quantity)
+	return err
+}
+
+// itemUnitErrorResponse maps errors from setBaseQuantity to a response
+func itemUnitErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errProductNotFound) {
+		return problem.BadRequest("product_not_found", "Product not found")
+	}
+	return unitErrorResponse(c, err)
+}
+
+// orderOfRequest returns the ID of the order named by the id parameter, or a
+// 404 if there is no such order
+func orderOfRequest(c *fiber.Ctx) (uuid.UUID, error) {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, problem.NotFound("order_not_found", "Order not found")
+	}
+	exists, err := tenantDB(c).NewSelect().
+		Model((*models.Orders)(nil)).
+		Where("id = ?", orderID).
+		Apply(tenancy.Scope(requestContext(c))).
+		Exists(requestContext(c))
+	if err != nil {
+		return uuid.Nil, problem.Internal(err, "Failed to fetch order")
+	}
+	if !exists {
+		return uuid.Nil, problem.NotFound("order_not_found", "Order not found")
+	}
+	return orderID, nil
+}
+
+// GetAllOrderItems lists the items of an order
+func GetAllOrderItems(c *fiber.Ctx) error {
+	orderID, err := orderOfRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var orderItems []models.OrderItem
+	err = tenantDB(c).NewSelect().Model(&orderItems).Where("order_id = ?", orderID).Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch order items")
+	}
+
+	if len(orderItems) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.OrderItem{})
+	}
+	return c.Status(fiber.StatusOK).JSON(orderItems)
+}
+
+// CreateOrderItem adds an item to an order. Its quantity may be given in any
+// unit of the product and is converted into the base unit.
+func CreateOrderItem(c *fiber.Ctx) error {
+	orderID, err := orderOfRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var orderItem models.OrderItem
+	if err := c.BodyParser(&orderItem); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body")
+	}
+	orderItem.ID = uuid.Nil
+	orderItem.OrderID = orderID
+
+	err = setBaseQuantity(requestContext(c), tenantDB(c), orderItem.ProductID, orderItem.Unit, orderItem.Quantity, &orderItem.BaseQuantity)
+	if err != nil {
+		return itemUnitErrorResponse(c, err)
+	}
+
+	err = insertAudited(c, audit.OrderItem, &orderItem)
+	if err != nil {
+		return problem.Internal(err, "Failed to create order item")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(orderItem)
+}
+
+// findOrderItem loads the item named by the itemId parameter of the order
+// named by the id parameter
+func findOrderItem(c *fiber.Ctx, orderItem *models.OrderItem) error {
+	err := tenantDB(c).NewSelect().
+		Model(orderItem).
+		Where("id = ? AND order_id = ?", c.Params("itemId"), c.Params("id")).
+		Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("order_item_not_found", "Order item not found")
+	}
+	return nil
+}
+
+// GetOneOrderItem returns an item of an order
+func GetOneOrderItem(c *fiber.Ctx) error {
+	var orderItem models.OrderItem
+	if err := findOrderItem(c, &orderItem); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(orderItem)
+}
+
+// UpdateOrderItem replaces an item of an order, converting its quantity as
+// CreateOrderItem does
+func UpdateOrderItem(c *fiber.Ctx) error {
+	var orderItem models.OrderItem
+	if err := findOrderItem(c, &orderItem); err != nil {
+		return err
+	}
+	id, orderID := orderItem.ID, orderItem.OrderID
+
+	if err := c.BodyParser(&orderItem); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body")
+	}
+	// Items stay on their order
+	orderItem.ID, orderItem.OrderID = id, orderID
+
+	err := setBaseQuantity(requestContext(c), tenantDB(c), orderItem.ProductID, orderItem.Unit, orderItem.Quantity, &orderItem.BaseQuantity)
+	if err != nil {
+		return itemUnitErrorResponse(c, err)
+	}
+
+	err = updateAudited(c, audit.OrderItem, id, &orderItem)
+	if err != nil {
+		return problem.Internal(err, "Failed to update order item")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(orderItem)
+}
+
+// DeleteOrderItem removes an item from an order
+func DeleteOrderItem(c *fiber.Ctx) error {
+	var orderItem models.OrderItem
+	if err := findOrderItem(c, &orderItem); err != nil {
+		return err
+	}
+
+	err := deleteAudited(c, audit.OrderItem, orderItem.ID, &models.OrderItem{})
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("order_item_not_found", "Order item not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete order item")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/google/uuid"
+)
+
+func TestOrderItems(t *testing.T) {
+	s := newDBServer(t)
+	productID, _ := s.stocked(0)
+	orderID := s.seed("INSERT INTO orders (order_date, status, total_amount) VALUES (now(), 'pending', 0) RETURNING id")
+	path := "/orders/" + orderID.String() + "/items"
+
+	var item models.OrderItem
+	status := s.do(http.MethodPost, path, map[string]interface{}{"ProductID": productID, "Quantity": 2, "Unit": "case", "Price": 10}, &item)
+	if status != http.StatusCreated {
+		t.Fatalf("adding an item answered %d, want 201", status)
+	}
+	if item.OrderID != orderID || item.BaseQuantity != 48 {
+		t.Errorf("item is on order %s with %v base units, want %s and 48", item.OrderID, item.BaseQuantity, orderID)
+	}
+
+	var failure problemBody
+	status = s.do(http.MethodPost, path, map[string]interface{}{"ProductID": productID, "Quantity": 1, "Unit": "pallet"}, &failure)
+	if status != http.StatusUnprocessableEntity || len(failure.Errors) != 1 || failure.Errors[0].Code != validation.NotFound {
+		t.Errorf("adding an item in an unknown unit answered %d %+v, want 422 with a not_found error", status, failure.Errors)
+	}
+	if status := s.do(http.MethodPost, "/orders/"+uuid.NewString()+"/items", map[string]interface{}{"ProductID": productID, "Quantity": 1}, nil); status != http.StatusNotFound {
+		t.Errorf("adding an item to a missing order answered %d, want 404", status)
+	}
+
+	itemPath := path + "/" + item.ID.String()
+	var updated models.OrderItem
+	if status := s.do(http.MethodPut, itemPath, map[string]interface{}{"ProductID": productID, "Quantity": 3, "OrderID": uuid.New()}, &updated); status != http.StatusOK {
+		t.Fatalf("updating the item answered %d, want 200", status)
+	}
+	if updated.OrderID != orderID || updated.BaseQuantity != 3 {
+		t.Errorf("updated item is on order %s with %v base units, want %s and 3", updated.OrderID, updated.BaseQuantity, orderID)
+	}
+
+	var items []models.OrderItem
+	if status := s.do(http.MethodGet, path, nil, &items); status != http.StatusOK || len(items) != 1 {
+		t.Errorf("listing the items answered %d with %d items, want 200 with 1", status, len(items))
+	}
+	if status := s.do(http.MethodDelete, itemPath, nil, nil); status != http.StatusNoContent {
+		t.Errorf("deleting the item answered %d, want 204", status)
+	}
+	if status := s.do(http.MethodGet, itemPath, nil, nil); status != http.StatusNotFound {
+		t.Errorf("deleted item answered %d, want 404", status)
+	}
+}
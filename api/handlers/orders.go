@@ -1,142 +1,133 @@
-package handlers
-
-import (
-	"log"
-
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
-	"github.com/gofiber/fiber/v2"
-)
-
-func GetAllOrders(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-	
-	_, err := db.NewCreateTable().Model(&models.Orders{}).IfNotExists().Exec(dbCtx)
-	
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "An unexpected error occurred",
-		})
-	}
-
-	var orders []models.Orders
-	err = db.NewSelect().Model(&orders).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	if len(orders) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]models.Orders{})
-	}
-	return c.Status(fiber.StatusOK).JSON(orders)
-}
-
-func CreateOrder(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	var order models.Orders
-	if err := c.BodyParser(&order); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	_, err = db.NewInsert().Model(&order).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create order",
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(order)
-}
-
-func GetOneOrder(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var order models.Orders
-
-	err = db.NewSelect().Model(&order).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order not found",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(order)
-}
-
-func UpdateOrder(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var order models.Orders
-
-	err = db.NewSelect().Model(&order).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order not found",
-		})
-	}
-
-	if err := c.BodyParser(&order); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	_, err = db.NewUpdate().Model(&order).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update order",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(order)
-}
-
-func DeleteOrder(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-
-	result, err := db.NewDelete().Model((*models.Orders)(nil)).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete order",
-		})
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order not found",
-		})
-	}
-
-	return c.SendStatus(fiber.StatusNoContent)
-}
\ No newline at end of file
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// OrderHandler serves the order endpoints
+type OrderHandler struct {
+	orders repository.OrderRepository
+}
+
+func NewOrderHandler(repos repository.Repositories) *OrderHandler {
+	return &OrderHandler{orders: repos.Orders}
+}
+
+func (h *OrderHandler) List(c *fiber.Ctx) error {
+	orders, err := h.orders.List(requestContext(c))
+	if err != nil {
+		return err
+	}
+
+	if len(orders) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.Orders{})
+	}
+	return c.Status(fiber.StatusOK).JSON(orders)
+}
+
+func (h *OrderHandler) Create(c *fiber.Ctx) error {
+	var order models.Orders
+	if err := c.BodyParser(&order); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body")
+	}
+	if errs := validation.Struct(&order); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	err := h.orders.Create(requestContext(c), &order)
+	if err != nil {
+		return problem.Internal(err, "Failed to create order")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+func (h *OrderHandler) Get(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+
+	order, err := h.orders.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+func (h *OrderHandler) Update(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+
+	order, err := h.orders.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+
+	if err := c.BodyParser(order); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body")
+	}
+	order.Id = id
+	if errs := validation.Struct(order); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	err = h.orders.Update(requestContext(c), order)
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to update order")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+// Patch changes only the fields named in a merge patch or JSON Patch
+func (h *OrderHandler) Patch(c *fiber.Ctx) error {
+	patch := func(ctx context.Context, id uuid.UUID, _ repository.Precondition, change repository.Change[models.Orders]) (*models.Orders, error) {
+		// Orders are not versioned
+		return h.orders.Patch(ctx, id, change)
+	}
+	order, err := patchResource(c, patch, func(ctx context.Context, before, after *models.Orders) error {
+		return validation.Struct(after).Err()
+	})
+	if err != nil {
+		return patchErrorResponse(c, err, "Order")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+func (h *OrderHandler) Delete(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+
+	err = h.orders.Delete(requestContext(c), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("order_not_found", "Order not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete order")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
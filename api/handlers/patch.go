@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/patch"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var errUnsupportedPatchType = errors.New("unsupported patch media type")
+
+// permissionError is returned when a change needs a permission the principal lacks
+type permissionError struct {
+	permission string
+}
+
+func (e *permissionError) Error() string {
+	return "missing permission " + e.permission
+}
+
+// patchValidator checks a patched resource before it is saved. before and
+// after point to the row as stored and as patched; the validator may fill in
+// defaults on after. The bulk endpoints also use it for new resources, which
+// have a nil before.
+type patchValidator[T any] func(ctx context.Context, before, after *T) error
+
+// patchFunc patches the row with the given ID through a repository, such as
+// ProductRepository.Patch
+type patchFunc[T any] func(ctx context.Context, id uuid.UUID, check repository.Precondition, change repository.Change[T]) (*T, error)
+
+// patchMediaType returns the patch format of the request. Plain JSON is
+// taken as a merge patch.
+func patchMediaType(c *fiber.Ctx) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(c.Get(fiber.HeaderContentType), ";")[0]))
+	if mediaType == fiber.MIMEApplicationJSON {
+		return patch.MergePatchType
+	}
+	return mediaType
+}
+
+// patchResource applies the request's merge patch or JSON Patch to the
+// resource named by the id parameter with apply, checking versioned rows
+// against If-Match. It returns repository.ErrNotFound if there is no such
+// resource.
+func patchResource[T any](c *fiber.Ctx, apply patchFunc[T], validate patchValidator[T]) (*T, error) {
+	mediaType := patchMediaType(c)
+	if mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType {
+		return nil, errUnsupportedPatchType
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+
+	return apply(requestContext(c), id, ifMatch(c), patchChange(mediaType, c.Body(), validate))
+}
+
+// patchChange applies a patch document of the given media type to the JSON
+// of a row and validates the result, which replaces the row. Members the row
+// does not have are refused rather than dropped, so a misspelt field is not
+// taken for a successful change.
+func patchChange[T any](mediaType string, document []byte, validate patchValidator[T]) repository.Change[T] {
+	return func(ctx context.Context, row *T) error {
+		doc, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		patched, err := patch.Apply(mediaType, doc, document)
+		if err != nil {
+			return err
+		}
+
+		var after T
+		decoder := json.NewDecoder(bytes.NewReader(patched))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&after); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return validationError(typeErr.Field, validation.Invalid, typeErr.Field+" cannot be a "+typeErr.Value)
+			}
+			if field, ok := unknownField(err); ok {
+				return validationError(field, validation.Unknown, field+" is not a field of this resource")
+			}
+			return validationError("", validation.Invalid, err.Error())
+		}
+
+		if validate != nil {
+			if err := validate(ctx, row, &after); err != nil {
+				return err
+			}
+		}
+		*row = after
+		return nil
+	}
+}
+
+// unknownField returns the member a decoder refused with DisallowUnknownFields,
+// which encoding/json only reports in the error message
+func unknownField(err error) (string, bool) {
+	quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return "", false
+	}
+	field, err := strconv.Unquote(quoted)
+	return field, err == nil
+}
+
+// patchErrorResponse maps errors from patchResource to a response. name is the
+// resource as it appears in messages, such as "Product".
+func patchErrorResponse(c *fiber.Ctx, err error, name string) error {
+	var failure *problem.Problem
+	var invalid validation.Errors
+	var forbidden *permissionError
+	var readOnly *repository.ReadOnlyError
+	var clash *repository.AttributeClashError
+
+	switch {
+	case errors.As(err, &failure):
+		return failure
+	case errors.Is(err, sql.ErrNoRows):
+		return problem.NotFound(strings.ToLower(name)+"_not_found", name+" not found")
+	case isPreconditionError(err):
+		return preconditionErrorResponse(c, err)
+	case errors.Is(err, errUnsupportedPatchType):
+		return problem.New(fiber.StatusUnsupportedMediaType, "unsupported_patch_format", "Unsupported patch format").WithDetail("Send " + patch.MergePatchType + " or " + patch.JSONPatchType)
+	case errors.Is(err, patch.ErrTestFailed):
+		return problem.Conflict("patch_test_failed", "Patch test failed").WithDetail(err.Error())
+	case errors.Is(err, patch.ErrInvalid):
+		return problem.BadRequest("invalid_patch", "Invalid patch").WithDetail(err.Error())
+	case errors.As(err, &invalid):
+		return validationErrorResponse(c, invalid)
+	case errors.As(err, &forbidden):
+		return problem.Forbidden("forbidden", "Forbidden").WithDetail("Missing permission " + forbidden.permission)
+	case errors.As(err, &readOnly):
+		return validationErrorResponse(c, validationError(readOnly.Column, validation.ReadOnly, readOnly.Error()))
+	case errors.Is(err, repository.ErrCategoryCycle), errors.As(err, &clash):
+		return categoryParentErrorResponse(c, err)
+	}
+	if failure = databaseErrorResponse(err, name); failure != nil {
+		return failure
+	}
+
+	return problem.Internal(err, "Failed to update "+strings.ToLower(name))
+}
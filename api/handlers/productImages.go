@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/storage"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"golang.org/x/image/draw"
+)
+
+// ImageStorage is where uploaded product images are kept. It is set by main.
+var ImageStorage storage.Storage
+
+// MaxImageSize is the largest image upload accepted, in bytes
+const MaxImageSize = 4 * 1024 * 1024
+
+// MaxImagePixels is the largest image upload accepted, in pixels. A small
+// file can describe a huge image, which decoding would allocate in full.
+// It is set by main.
+var MaxImagePixels = 25_000_000
+
+// allowedImageTypes are the content types accepted for upload, detected from the file contents
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// thumbnailSizes maps thumbnail names to the length of their longest side in pixels
+var thumbnailSizes = map[string]int{
+	"small":  150,
+	"medium": 400,
+	"large":  800,
+}
+
+// withImageURLs fills in the URL fields of images from their storage keys
+func withImageURLs(images []models.ProductImage) []models.ProductImage {
+	for i := range images {
+		images[i].URL = ImageStorage.URL(images[i].Key)
+		images[i].ThumbnailURLs = make(map[string]string, len(images[i].ThumbnailKeys))
+		for name, key := range images[i].ThumbnailKeys {
+			images[i].ThumbnailURLs[name] = ImageStorage.URL(key)
+		}
+	}
+	return images
+}
+
+// makeThumbnail scales img down so its longest side is at most size pixels and encodes it as JPEG
+func makeThumbnail(img image.Image, size int) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			height = max(1, height*size/width)
+			width = size
+		} else {
+			width = max(1, width*size/height)
+			height = size
+		}
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deleteStoredImage removes an image and its thumbnails from storage, logging failures
+func deleteStoredImage(ctx context.Context, img models.ProductImage) {
+	keys := []string{img.Key}
+	for _, key := range img.ThumbnailKeys {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if err := ImageStorage.Delete(ctx, key); err != nil {
+			slog.WarnContext(ctx, "Failed to delete stored image", "key", key, "error", err)
+		}
+	}
+}
+
+// syncPrimaryImageURL points Products.ImageURL at the first image so clients
+// reading the single-image field keep working
+func syncPrimaryImageURL(ctx context.Context, idb bun.IDB, productID uuid.UUID) error {
+	var first models.ProductImage
+	err := idb.NewSelect().
+		Model(&first).
+		Where("product_id = ?", productID).
+		Order("position").
+		Limit(1).
+		Scan(ctx)
+
+	url := ""
+	if err == nil {
+		url = ImageStorage.URL(first.Key)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var previous string
+	err = idb.NewSelect().
+		Model((*models.Products)(nil)).
+		Column("image_url").
+		Where("id = ?", productID).
+		Scan(ctx, &previous)
+	if err != nil || previous == url {
+		return err
+	}
+
+	_, err = idb.NewUpdate().
+		Model((*models.Products)(nil)).
+		Set("image_url = ?", url).
+		Where("id = ?", productID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, idb, audit.Update, audit.Product, productID,
+		fiber.Map{"image_url": previous}, fiber.Map{"image_url": url})
+}
+
+// storeImage validates an uploaded file, generates its thumbnails and writes everything to storage
+func storeImage(ctx context.Context, productID uuid.UUID, data []byte) (*models.ProductImage, error) {
+	contentType := http.DetectContentType(data)
+	extension, allowed := allowedImageTypes[contentType]
+	if !allowed {
+		return nil, problem.New(fiber.StatusUnsupportedMediaType, "unsupported_image_type", "Unsupported image type").
+			Detailf("Got %s, expected JPEG, PNG or GIF", contentType)
+	}
+
+	// The dimensions are read from the header before anything is decoded
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, problem.New(fiber.StatusUnsupportedMediaType, "invalid_image", "Invalid image").WithDetail(err.Error())
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > int64(MaxImagePixels) {
+		return nil, problem.New(fiber.StatusRequestEntityTooLarge, "image_too_large", "Image too large").
+			Detailf("The image is %dx%d pixels, the limit is %d pixels", config.Width, config.Height, MaxImagePixels)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, problem.New(fiber.StatusUnsupportedMediaType, "invalid_image", "Invalid image").WithDetail(err.Error())
+	}
+
+	img := &models.ProductImage{
+		ID:            uuid.New(),
+		ProductID:     productID,
+		ContentType:   contentType,
+		Size:          int64(len(data)),
+		ThumbnailKeys: make(map[string]string, len(thumbnailSizes)),
+	}
+	prefix := fmt.Sprintf("products/%s/%s", productID, img.ID)
+	img.Key = prefix + extension
+
+	if err := ImageStorage.Put(ctx, img.Key, bytes.NewReader(data), img.Size, contentType); err != nil {
+		return nil, err
+	}
+	for name, size := range thumbnailSizes {
+		thumbnail, err := makeThumbnail(decoded, size)
+		if err != nil {
+			deleteStoredImage(ctx, *img)
+			return nil, err
+		}
+		key := fmt.Sprintf("%s_%s.jpg", prefix, name)
+		if err := ImageStorage.Put(ctx, key, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+			deleteStoredImage(ctx, *img)
+			return nil, err
+		}
+		img.ThumbnailKeys[name] = key
+	}
+
+	return img, nil
+}
+
+// GetProductImages lists a product's images in display order
+func GetProductImages(c *fiber.Ctx) error {
+	var images []models.ProductImage
+	err := tenantDB(c).NewSelect().
+		Model(&images).
+		Where("product_id = ?", c.Params("id")).
+		Order("position").
+		Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch images")
+	}
+
+	if len(images) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.ProductImage{})
+	}
+	return c.Status(fiber.StatusOK).JSON(withImageURLs(images))
+}
+
+// GetStoredImage serves an image or thumbnail stored under the key in the
+// path, if it belongs to one of the tenant's products. The local backend's
+// files are only served through it.
+func GetStoredImage(c *fiber.Ctx) error {
+	key := c.Params("*")
+	var img models.ProductImage
+	err := tenantDB(c).NewSelect().
+		Model(&img).
+		Where("pi.key = ? OR EXISTS (SELECT 1 FROM jsonb_each_text(pi.thumbnail_keys) AS thumbnail WHERE thumbnail.value = ?)", key, key).
+		Limit(1).
+		Scan(requestContext(c))
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("image_not_found", "Image not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch image")
+	}
+
+	file, err := ImageStorage.Get(requestContext(c), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return problem.NotFound("image_not_found", "Image not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch image")
+	}
+
+	// Thumbnails are always JPEG
+	contentType := img.ContentType
+	if key != img.Key {
+		contentType = "image/jpeg"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "private")
+	return c.SendStream(file)
+}
+
+// UploadProductImages accepts one or more multipart files in the "images"
+// field and appends them to the product's images
+func UploadProductImages(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	exists, err := tenantDB(c).NewSelect().
+		Model((*models.Products)(nil)).
+		Where("id = ?", productID).
+		Apply(tenancy.Scope(requestContext(c))).
+		Exists(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to upload images")
+	}
+	if !exists {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["images"]) == 0 {
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail("Expected multipart/form-data with one or more files in the \"images\" field")
+	}
+
+	var stored []models.ProductImage
+	cleanup := func() {
+		for _, img := range stored {
+			deleteStoredImage(requestContext(c), img)
+		}
+	}
+
+	for _, header := range form.File["images"] {
+		if header.Size > MaxImageSize {
+			cleanup()
+			return problem.New(fiber.StatusRequestEntityTooLarge, "image_too_large", "Image too large").Detailf("%s is %d bytes, the limit is %d", header.Filename, header.Size, MaxImageSize)
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			cleanup()
+			slog.DebugContext(c.UserContext(), "Invalid upload", "error", err)
+			return problem.BadRequest("invalid_request_body", "Invalid request body")
+		}
+		data, err := io.ReadAll(io.LimitReader(file, MaxImageSize+1))
+		file.Close()
+		if err != nil {
+			cleanup()
+			slog.DebugContext(c.UserContext(), "Invalid upload", "error", err)
+			return problem.BadRequest("invalid_request_body", "Invalid request body")
+		}
+
+		img, err := storeImage(requestContext(c), productID, data)
+		if err != nil {
+			cleanup()
+			var invalid *problem.Problem
+			if errors.As(err, &invalid) {
+				return invalid.With("file", header.Filename)
+			}
+			return problem.Internal(err, "Failed to store image")
+		}
+		stored = append(stored, *img)
+	}
+
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Lock the product so concurrent uploads get distinct positions
+		_, err := tx.NewSelect().Model((*models.Products)(nil)).Where("id = ?", productID).For("UPDATE").Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		var next int
+		err = tx.NewSelect().
+			Model((*models.ProductImage)(nil)).
+			ColumnExpr("COALESCE(MAX(position) + 1, 0)").
+			Where("product_id = ?", productID).
+			Scan(ctx, &next)
+		if err != nil {
+			return err
+		}
+		for i := range stored {
+			stored[i].Position = next + i
+		}
+
+		if _, err := tx.NewInsert().Model(&stored).Exec(ctx); err != nil {
+			return err
+		}
+		for i := range stored {
+			if err := audit.Record(ctx, tx, audit.Create, audit.ProductImage, stored[i].ID, nil, &stored[i]); err != nil {
+				return err
+			}
+		}
+		return syncPrimaryImageURL(ctx, tx, productID)
+	})
+	if err != nil {
+		cleanup()
+		return problem.Internal(err, "Failed to save images")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(withImageURLs(stored))
+}
+
+// ReorderProductImages sets the display order of a product's images from an
+// array of image IDs, which must list every image exactly once
+func ReorderProductImages(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var order []uuid.UUID
+	if err := c.BodyParser(&order); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	var images []models.ProductImage
+	errMismatch := errors.New("image order mismatch")
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(&images).Where("product_id = ?", productID).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		byID := make(map[uuid.UUID]int, len(images))
+		for i, img := range images {
+			byID[img.ID] = i
+		}
+		if len(order) != len(images) {
+			return errMismatch
+		}
+
+		reordered := make([]models.ProductImage, 0, len(images))
+		for _, id := range order {
+			i, found := byID[id]
+			if !found {
+				return errMismatch
+			}
+			delete(byID, id)
+			reordered = append(reordered, images[i])
+		}
+		images = reordered
+
+		for position := range images {
+			img := &images[position]
+			if img.Position == position {
+				continue
+			}
+			before := fiber.Map{"position": img.Position}
+			img.Position = position
+			_, err := tx.NewUpdate().Model(img).Column("position").WherePK().Exec(ctx)
+			if err != nil {
+				return err
+			}
+			err = audit.Record(ctx, tx, audit.Update, audit.ProductImage, img.ID, before, fiber.Map{"position": position})
+			if err != nil {
+				return err
+			}
+		}
+		return syncPrimaryImageURL(ctx, tx, productID)
+	})
+
+	if errors.Is(err, errMismatch) {
+		return validationErrorResponse(c, validationError("order", validation.Invalid, "The order must list every image of the product exactly once"))
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to reorder images")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(withImageURLs(images))
+}
+
+// DeleteProductImage removes an image and its thumbnails
+func DeleteProductImage(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var img models.ProductImage
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model(&img).
+			Where("id = ? AND product_id = ?", c.Params("imageId"), productID).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if img.ID == uuid.Nil {
+			return sql.ErrNoRows
+		}
+		if err := audit.Record(ctx, tx, audit.Delete, audit.ProductImage, img.ID, &img, nil); err != nil {
+			return err
+		}
+		return syncPrimaryImageURL(ctx, tx, productID)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("image_not_found", "Image not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete image")
+	}
+
+	deleteStoredImage(requestContext(c), img)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestGetStoredImage(t *testing.T) {
+	s := newDBServer(t)
+	local, err := storage.NewLocalStorage(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageStorage := ImageStorage
+	ImageStorage = local
+	t.Cleanup(func() { ImageStorage = imageStorage })
+
+	productID, _ := s.stocked(0)
+	key := "products/" + productID.String() + "/" + uuid.NewString() + ".png"
+	thumbnail := key[:len(key)-len(".png")] + "_small.jpg"
+	for _, stored := range []string{key, thumbnail} {
+		if err := local.Put(context.Background(), stored, bytes.NewReader([]byte(stored)), int64(len(stored)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.seed(`INSERT INTO product_images (product_id, position, content_type, size, key, thumbnail_keys)
+		VALUES (?, 0, 'image/png', ?, ?, jsonb_build_object('small', ?::text)) RETURNING id`, productID, len(key), key, thumbnail)
+
+	get := func(token, path string) (*http.Response, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+		resp, err := s.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	for _, test := range []struct{ key, contentType string }{{key, "image/png"}, {thumbnail, "image/jpeg"}} {
+		resp, body := get(s.token, "/uploads/"+test.key)
+		if resp.StatusCode != http.StatusOK || body != test.key {
+			t.Errorf("fetching %s answered %d %q, want 200 with the stored file", test.key, resp.StatusCode, body)
+		}
+		if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != test.contentType {
+			t.Errorf("%s was served as %s, want %s", test.key, contentType, test.contentType)
+		}
+	}
+
+	if resp, _ := get("", "/uploads/"+key); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("fetching without a token answered %d, want 401", resp.StatusCode)
+	}
+	if resp, _ := get(s.tokenFor(uuid.New()), "/uploads/"+key); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("fetching another tenant's image answered %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := get(s.token, "/uploads/products/"+productID.String()+"/missing.png"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("fetching an unknown key answered %d, want 404", resp.StatusCode)
+	}
+}
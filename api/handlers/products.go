@@ -1,380 +1,434 @@
-package handlers
-
-import (
-	"context"
-	"log"
-	"time"
-
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
-	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
-)
-
-var db, err = database.ConnectDb()
-var dbCtx context.Context
-var cancel context.CancelFunc
-func init() {
-	dbCtx, cancel = context.WithTimeout(context.Background(), 30*24*time.Hour)
-}
-
-func Getall(c *fiber.Ctx) error {
-	log.Println("Starting Getall function")
-	
-	if err != nil {
-		log.Printf("Initial Database Connection Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Database connection failed",
-		})
-	}
-	log.Println("Database connection successful")
-	
-	// Create table if not exists
-	log.Println("Attempting to create table if not exists")
-	_, err := db.NewCreateTable().
-		Model(&models.Products{}).
-		IfNotExists().
-		Exec(dbCtx)
-	
-	if err != nil {
-		log.Printf("Table Creation Error: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create table",
-			"details": err.Error(),
-		})
-	}
-	log.Println("Table creation/check completed")
-
-	log.Println("Attempting to fetch products")
-	var products []models.Products
-	err = db.NewSelect().
-		Model(&products).
-		Relation("Category").
-		Relation("Supplier").
-		
-		Scan(dbCtx)
-
-	if err != nil {
-		log.Printf("Product Query Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch products",
-			"details": err.Error(),
-		})
-	}
-	log.Printf("Successfully fetched %d products", len(products))
-
-	if len(products) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]models.Products{})
-	}
-	return c.Status(fiber.StatusOK).JSON(products)
-}
-
-// Create a new product
-func Create(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	// Create a struct to parse the JSON request
-	var requestData struct {
-		Name       string  `json:"name"`
-		CategoryID string  `json:"category_id"`
-		Price      float64 `json:"price"`
-		Quantity   int     `json:"quantity"`
-		ImageURL   string  `json:"image_url,omitempty"`
-		SupplierID string  `json:"supplier_id"`
-	}
-
-	// Parse JSON body
-	if err := c.BodyParser(&requestData); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
-	}
-
-	// Parse Category ID
-	categoryID, err := uuid.Parse(requestData.CategoryID)
-	if err != nil {
-		log.Printf("Category ID Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid category ID format",
-			"details": err.Error(),
-		})
-	}
-
-	// Parse Supplier ID
-	supplierID, err := uuid.Parse(requestData.SupplierID)
-	if err != nil {
-		log.Printf("Supplier ID Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid supplier ID format",
-			"details": err.Error(),
-		})
-	}
-
-	// Create the product
-	product := models.Products{
-		Name:       requestData.Name,
-		CategoryID: categoryID,
-		Price:      requestData.Price,
-		Quantity:   requestData.Quantity,
-		ImageURL:   requestData.ImageURL,
-		SupplierID: supplierID,
-	}
-
-	// Insert the product
-	_, err = db.NewInsert().
-		Model(&product).
-		Exec(dbCtx)
-
-	if err != nil {
-		log.Printf("Insert Error: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create product",
-			"details": err.Error(),
-		})
-	}
-
-	// Load relations
-	err = db.NewSelect().
-		Model(&product).
-		Relation("Category").
-		Relation("Supplier").
-		Where("products.id = ?", product.ID).
-		Scan(dbCtx)
-
-	if err != nil {
-		log.Printf("Error loading relations: %s", err)
-	}
-
-	// Create response struct without CategoryID and SupplierID
-	response := struct {
-		ID       uuid.UUID        `json:"ID"`
-		Name     string          `json:"Name"`
-		Category models.Category `json:"Category"`
-		Price    float64         `json:"Price"`
-		Quantity int             `json:"Quantity"`
-		ImageURL string          `json:"ImageURL"`
-		Supplier models.Supplier `json:"Supplier"`
-	}{
-		ID:       product.ID,
-		Name:     product.Name,
-		Category: product.Category,
-		Price:    product.Price,
-		Quantity: product.Quantity,
-		ImageURL: product.ImageURL,
-		Supplier: product.Supplier,
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(response)
-}
-
-// Get a single product by ID
-func GetOne(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var product models.Products
-
-	err = db.NewSelect().
-		Model(&product).
-		Relation("Category").
-		Relation("Supplier").
-		Where("products.id = ?", id).
-		Scan(dbCtx)
-
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Product not found",
-		})
-	}
-
-	// Create response struct without CategoryID and SupplierID
-	response := struct {
-		ID       uuid.UUID        `json:"ID"`
-		Name     string          `json:"Name"`
-		Category models.Category `json:"Category"`
-		Price    float64         `json:"Price"`
-		Quantity int             `json:"Quantity"`
-		ImageURL string          `json:"ImageURL"`
-		Supplier models.Supplier `json:"Supplier"`
-	}{
-		ID:       product.ID,
-		Name:     product.Name,
-		Category: product.Category,
-		Price:    product.Price,
-		Quantity: product.Quantity,
-		ImageURL: product.ImageURL,
-		Supplier: product.Supplier,
-	}
-
-	return c.Status(fiber.StatusOK).JSON(response)
-}
-
-// Update a product
-func Update(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var product models.Products
-	err = db.NewSelect().Model(&product).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Product not found",
-		})
-	}
-
-	if err := c.BodyParser(&product); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	_, err = db.NewUpdate().Model(&product).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update product",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(product)
-}
-
-// Delete a product
-func Delete(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-
-	result, err := db.NewDelete().Model((*models.Products)(nil)).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete product",
-		})
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Product not found",
-		})
-	}
-
-	return c.SendStatus(fiber.StatusNoContent)
-}
-
-// GetProductsByCategory retrieves all products in a specific category
-func GetProductsByCategory(c *fiber.Ctx) error {
-	log.Println("Starting GetProductsByCategory function")
-	
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	categoryID := c.Params("categoryId")
-	
-	// Parse the category ID string to UUID
-	parsedCategoryID, err := uuid.Parse(categoryID)
-	if err != nil {
-		log.Printf("Category ID Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid category ID format",
-			"details": err.Error(),
-		})
-	}
-
-	// Modified to select only ID and Name
-	var products []struct {
-		ID   uuid.UUID `json:"id"`
-		Name string    `json:"name"`
-	}
-	err = db.NewSelect().
-		Model((*models.Products)(nil)).
-		Column("id", "name").
-		Where("category_id = ?", parsedCategoryID).
-		Scan(dbCtx, &products)
-
-	if err != nil {
-		log.Printf("Database Query Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch products",
-			"details": err.Error(),
-		})
-	}
-
-	log.Printf("Successfully fetched %d products for category %s", len(products), categoryID)
-
-	if len(products) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]struct{}{})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(products)
-}
-
-// GetProductsBySupplier retrieves all products from a specific supplier
-func GetProductsBySupplier(c *fiber.Ctx) error {
-	log.Println("Starting GetProductsBySupplier function")
-	
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	supplierID := c.Params("supplierId")
-	
-	// Parse the supplier ID string to UUID
-	parsedSupplierID, err := uuid.Parse(supplierID)
-	if err != nil {
-		log.Printf("Supplier ID Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid supplier ID format",
-			"details": err.Error(),
-		})
-	}
-
-	// Modified to select only ID and Name
-	var products []struct {
-		ID   uuid.UUID `json:"id"`
-		Name string    `json:"name"`
-	}
-	err = db.NewSelect().
-		Model((*models.Products)(nil)).
-		Column("id", "name").
-		Where("supplier_id = ?", parsedSupplierID).
-		Scan(dbCtx, &products)
-
-	if err != nil {
-		log.Printf("Database Query Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch products",
-			"details": err.Error(),
-		})
-	}
-
-	log.Printf("Successfully fetched %d products for supplier %s", len(products), supplierID)
-
-	if len(products) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]struct{}{})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(products)
-}
-
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// db is the connection pool of TenantScope and of the handlers that do not
+// go through repositories: everything but the product, category, supplier and
+// order endpoints, which query bun directly through tenantDB. main sets it
+// with SetDB.
+var db *bun.DB
+
+// SetDB sets the connection pool the handlers use besides their repositories
+func SetDB(pool *bun.DB) {
+	db = pool
+}
+
+// ProductHandler serves the product endpoints from the repositories it is
+// created with. PATCH and the bulk endpoint apply their changes to the row's
+// JSON.
+type ProductHandler struct {
+	products   repository.ProductRepository
+	categories repository.CategoryRepository
+	suppliers  repository.SupplierRepository
+	tx         repository.Transactor
+}
+
+// NewProductHandler returns a ProductHandler that uses repos
+func NewProductHandler(repos repository.Repositories) *ProductHandler {
+	return &ProductHandler{
+		products:   repos.Products,
+		categories: repos.Categories,
+		suppliers:  repos.Suppliers,
+		tx:         repos.Tx,
+	}
+}
+
+// productResponse is a product with its category and supplier, but without
+// their IDs
+type productResponse struct {
+	ID           uuid.UUID              `json:"ID"`
+	Name         string                 `json:"Name"`
+	Category     models.Category        `json:"Category"`
+	Price        float64                `json:"Price"`
+	Quantity     float64                `json:"Quantity"`
+	ReorderPoint float64                `json:"ReorderPoint"`
+	BaseUnit     string                 `json:"BaseUnit"`
+	Fractional   bool                   `json:"Fractional"`
+	ImageURL     string                 `json:"ImageURL"`
+	Supplier     models.Supplier        `json:"Supplier"`
+	Attributes   map[string]interface{} `json:"Attributes"`
+}
+
+func newProductResponse(product *models.Products) productResponse {
+	return productResponse{
+		ID:           product.ID,
+		Name:         product.Name,
+		Category:     product.Category,
+		Price:        product.Price,
+		Quantity:     product.Quantity,
+		ReorderPoint: product.ReorderPoint,
+		BaseUnit:     product.BaseUnit,
+		Fractional:   product.Fractional,
+		ImageURL:     product.ImageURL,
+		Supplier:     product.Supplier,
+		Attributes:   product.Attributes,
+	}
+}
+
+// productSummary is what the per-category and per-supplier lists show of a product
+type productSummary struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+func summarizeProducts(products []models.Products) []productSummary {
+	summaries := make([]productSummary, len(products))
+	for i, product := range products {
+		summaries[i] = productSummary{ID: product.ID, Name: product.Name}
+	}
+	return summaries
+}
+
+// checkReferences records a not_found error for a product's category or
+// supplier unless it exists in the caller's tenant, so products never point at
+// another tenant's rows
+func (h *ProductHandler) checkReferences(ctx context.Context, categoryID, supplierID uuid.UUID, errs *validation.Errors) error {
+	if err := checkExists(ctx, h.categories.Exists, categoryID, "category_id", "Category", errs); err != nil {
+		return err
+	}
+	return checkExists(ctx, h.suppliers.Exists, supplierID, "supplier_id", "Supplier", errs)
+}
+
+// check validates a product about to be written: the rules declared on the
+// model, whole quantities for products that are not fractional, its category
+// and supplier, and its attributes. It fills in the default base unit.
+func (h *ProductHandler) check(ctx context.Context, product *models.Products) error {
+	if product.BaseUnit == "" {
+		product.BaseUnit = models.DefaultBaseUnit
+	}
+	errs := validation.Struct(product)
+	if !product.Fractional && !isWholeQuantity(product.Quantity) && !errs.Has("quantity") {
+		errs.Add("quantity", validation.Invalid, "quantity must be a whole number unless the product is fractional")
+	}
+	if err := h.checkReferences(ctx, product.CategoryID, product.SupplierID, &errs); err != nil {
+		return err
+	}
+	// Attributes are defined by the category, so they cannot be checked without one
+	if !errs.Has("category_id") {
+		definitions, err := h.categories.Attributes(ctx, product.CategoryID)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, validateProductAttributes(definitions, product.Attributes)...)
+	}
+	return errs.Err()
+}
+
+// List returns the products, narrowed by attr.<name>=<value> query parameters
+func (h *ProductHandler) List(c *fiber.Ctx) error {
+	attributes, err := attributeFilters(c)
+	if err != nil {
+		return problem.BadRequest("invalid_attribute_filter", "Invalid attribute filter").WithDetail(err.Error())
+	}
+	withDeleted, err := includeDeleted(c, auth.ProductsDelete)
+	if err != nil {
+		return err
+	}
+
+	products, err := h.products.List(requestContext(c), repository.ProductFilter{
+		Attributes:     attributes,
+		IncludeDeleted: withDeleted,
+	})
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch products")
+	}
+	slog.DebugContext(c.UserContext(), "Fetched products", "count", len(products))
+
+	if len(products) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.Products{})
+	}
+	return c.Status(fiber.StatusOK).JSON(products)
+}
+
+// Create a new product
+func (h *ProductHandler) Create(c *fiber.Ctx) error {
+	// Create a struct to parse the JSON request
+	var requestData struct {
+		Name         string                 `json:"name" validate:"required,max=255"`
+		CategoryID   string                 `json:"category_id" validate:"required,uuid"`
+		Price        float64                `json:"price" validate:"min=0"`
+		Quantity     float64                `json:"quantity" validate:"min=0"`
+		ReorderPoint float64                `json:"reorder_point,omitempty" validate:"min=0"`
+		BaseUnit     string                 `json:"base_unit,omitempty" validate:"max=50"`
+		Fractional   bool                   `json:"fractional,omitempty"`
+		ImageURL     string                 `json:"image_url,omitempty"`
+		SupplierID   string                 `json:"supplier_id" validate:"required,uuid"`
+		Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	}
+
+	// Parse JSON body
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	// The rules that need no database are checked first
+	if errs := validation.Struct(&requestData); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// Create the product
+	product := models.Products{
+		Name:         requestData.Name,
+		CategoryID:   uuid.MustParse(requestData.CategoryID),
+		Price:        requestData.Price,
+		Quantity:     requestData.Quantity,
+		ReorderPoint: requestData.ReorderPoint,
+		BaseUnit:     requestData.BaseUnit,
+		Fractional:   requestData.Fractional,
+		ImageURL:     requestData.ImageURL,
+		SupplierID:   uuid.MustParse(requestData.SupplierID),
+		Attributes:   requestData.Attributes,
+	}
+	if err := h.check(requestContext(c), &product); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	// Insert the product
+	if err := h.products.Create(requestContext(c), &product); err != nil {
+		return problem.Internal(err, "Failed to create product")
+	}
+
+	return h.send(c, fiber.StatusCreated, &product)
+}
+
+// send responds with a product just written, reloaded with its category and
+// supplier
+func (h *ProductHandler) send(c *fiber.Ctx, status int, product *models.Products) error {
+	loaded, err := h.products.Get(requestContext(c), product.ID)
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Failed to load the product's category and supplier", "error", err)
+	} else {
+		product = loaded
+	}
+
+	return sendVersioned(c, status, product.Version, newProductResponse(product))
+}
+
+// Get a single product by ID
+func (h *ProductHandler) Get(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	product, err := h.products.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, product.Version, newProductResponse(product))
+}
+
+// Update a product
+func (h *ProductHandler) Update(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	product, err := h.products.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+	// The body may move the product to another category or supplier, so the
+	// loaded ones are not written; the response loads them again
+	product.Category, product.Supplier = models.Category{}, models.Supplier{}
+
+	originalPrice := product.Price
+	if err := c.BodyParser(product); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body")
+	}
+	// Request bodies usually leave the ID out; the product keeps its own
+	product.ID = id
+
+	// Editing a product does not imply being allowed to reprice it
+	if product.Price != originalPrice && !auth.FromContext(c).Can(auth.PricesWrite) {
+		return problem.Forbidden("forbidden", "Forbidden").WithDetail("Missing permission " + auth.PricesWrite)
+	}
+
+	if err := h.check(requestContext(c), product); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	err = h.products.Update(requestContext(c), product, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to update product")
+	}
+
+	return h.send(c, fiber.StatusOK, product)
+}
+
+// Patch changes only the fields named in a merge patch or JSON Patch, so
+// fields left out keep their stored value
+func (h *ProductHandler) Patch(c *fiber.Ctx) error {
+	product, err := patchResource(c, h.products.Patch, h.validate(c))
+	if err != nil {
+		return patchErrorResponse(c, err, "Product")
+	}
+
+	return h.send(c, fiber.StatusOK, product)
+}
+
+// validate checks a patched or new product like Update and Create do
+func (h *ProductHandler) validate(c *fiber.Ctx) patchValidator[models.Products] {
+	return func(ctx context.Context, original, patched *models.Products) error {
+		if original != nil && patched.Price != original.Price && !auth.FromContext(c).Can(auth.PricesWrite) {
+			return &permissionError{permission: auth.PricesWrite}
+		}
+		return h.check(ctx, patched)
+	}
+}
+
+// Delete a product. It is only marked deleted, so orders keep referring to
+// it; its images stay until the purge job removes the product for good.
+func (h *ProductHandler) Delete(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	err = h.products.Delete(requestContext(c), id, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete product")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Restore undoes the deletion of a product whose category and supplier still
+// exist
+func (h *ProductHandler) Restore(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("deleted_product_not_found", "Deleted product not found")
+	}
+
+	product, err := h.products.GetDeleted(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("deleted_product_not_found", "Deleted product not found")
+	}
+
+	var errs validation.Errors
+	if err := h.checkReferences(requestContext(c), product.CategoryID, product.SupplierID, &errs); err != nil {
+		return validationErrorResponse(c, err)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	product, err = h.products.Restore(requestContext(c), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("deleted_product_not_found", "Deleted product not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to restore product")
+	}
+
+	return h.send(c, fiber.StatusOK, product)
+}
+
+// ListByCategory retrieves all products in a specific category
+func (h *ProductHandler) ListByCategory(c *fiber.Ctx) error {
+	categoryID := c.Params("categoryId")
+
+	// Parse the category ID string to UUID
+	parsedCategoryID, err := uuid.Parse(categoryID)
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid category ID", "error", err)
+		return problem.BadRequest("invalid_category_id", "Invalid category ID format").WithDetail(err.Error())
+	}
+
+	// ?include_descendants=true also matches products in every subcategory
+	categoryIDs := []uuid.UUID{parsedCategoryID}
+	if c.QueryBool("include_descendants") {
+		categoryIDs, err = h.categories.SubtreeIDs(requestContext(c), parsedCategoryID)
+		if err != nil {
+			return problem.Internal(err, "Failed to fetch products")
+		}
+		if len(categoryIDs) == 0 {
+			return c.Status(fiber.StatusNoContent).JSON([]productSummary{})
+		}
+	}
+
+	attributes, err := attributeFilters(c)
+	if err != nil {
+		return problem.BadRequest("invalid_attribute_filter", "Invalid attribute filter").WithDetail(err.Error())
+	}
+	products, err := h.products.List(requestContext(c), repository.ProductFilter{
+		CategoryIDs: categoryIDs,
+		Attributes:  attributes,
+	})
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch products")
+	}
+
+	slog.DebugContext(c.UserContext(), "Fetched products", "count", len(products), "category_id", categoryID)
+
+	if len(products) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]productSummary{})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(summarizeProducts(products))
+}
+
+// ListBySupplier retrieves all products from a specific supplier
+func (h *ProductHandler) ListBySupplier(c *fiber.Ctx) error {
+	supplierID := c.Params("supplierId")
+
+	// Parse the supplier ID string to UUID
+	parsedSupplierID, err := uuid.Parse(supplierID)
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid supplier ID", "error", err)
+		return problem.BadRequest("invalid_supplier_id", "Invalid supplier ID format").WithDetail(err.Error())
+	}
+
+	attributes, err := attributeFilters(c)
+	if err != nil {
+		return problem.BadRequest("invalid_attribute_filter", "Invalid attribute filter").WithDetail(err.Error())
+	}
+	products, err := h.products.List(requestContext(c), repository.ProductFilter{
+		SupplierID: parsedSupplierID,
+		Attributes: attributes,
+	})
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch products")
+	}
+
+	slog.DebugContext(c.UserContext(), "Fetched products", "count", len(products), "supplier_id", supplierID)
+
+	if len(products) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]productSummary{})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(summarizeProducts(products))
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+)
+
+func TestProductPatch(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	hardware := s.category("Hardware", nil)
+	hammer := s.product("Hammer", tools.ID, s.supplier("Acme").ID)
+	path := "/products/" + hammer.ID.String()
+
+	// Snake case is not how products are named, so this changes nothing
+	var failure problemBody
+	if status := s.do(http.MethodPatch, path, map[string]interface{}{"category_id": hardware.ID}, &failure); status != http.StatusUnprocessableEntity {
+		t.Fatalf("patching an unknown field answered %d, want 422", status)
+	}
+	if len(failure.Errors) != 1 || failure.Errors[0].Field != "category_id" || failure.Errors[0].Code != validation.Unknown {
+		t.Errorf("patching an unknown field failed with %+v, want an unknown error on category_id", failure.Errors)
+	}
+
+	var patched productResponse
+	if status := s.do(http.MethodPatch, path, map[string]interface{}{"CategoryID": hardware.ID}, &patched); status != http.StatusOK {
+		t.Fatalf("patching the category answered %d, want 200", status)
+	}
+	if patched.Category.ID != hardware.ID || patched.Category.Name != "Hardware" {
+		t.Errorf("patched into category %+v, want Hardware", patched.Category)
+	}
+	stored, err := s.repos.Products.Get(s.ctx(), hammer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != hammer.Version+1 {
+		t.Errorf("patched to version %d, want %d", stored.Version, hammer.Version+1)
+	}
+}
+
+func TestProductUpdateResponse(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	acme := s.supplier("Acme")
+	hammer := s.product("Hammer", tools.ID, acme.ID)
+
+	var updated map[string]interface{}
+	body := map[string]interface{}{"Name": "Claw hammer", "CategoryID": tools.ID, "SupplierID": acme.ID, "BaseUnit": "each"}
+	if status := s.do(http.MethodPut, "/products/"+hammer.ID.String(), body, &updated); status != http.StatusOK {
+		t.Fatalf("update answered %d, want 200", status)
+	}
+	// The response has the shape of GET, with the category and supplier
+	// instead of their IDs
+	for _, field := range []string{"CategoryID", "SupplierID", "Version", "DeletedAt"} {
+		if _, ok := updated[field]; ok {
+			t.Errorf("update response has %s", field)
+		}
+	}
+	category, _ := updated["Category"].(map[string]interface{})
+	supplier, _ := updated["Supplier"].(map[string]interface{})
+	if updated["Name"] != "Claw hammer" || category["Name"] != "Tools" || supplier["Name"] != "Acme" {
+		t.Errorf("update answered %v, want Claw hammer in Tools from Acme", updated)
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// purchaseOrderRequest is the body of POST /purchase-orders
+type purchaseOrderRequest struct {
+	SupplierID uuid.UUID                  `json:"supplier_id" validate:"required"`
+	Items      []purchaseOrderItemRequest `json:"items" validate:"required,max=500"`
+}
+
+type purchaseOrderItemRequest struct {
+	ProductID uuid.UUID `json:"product_id" validate:"required"`
+	Quantity  float64   `json:"quantity" validate:"gt=0"`
+	// Unit is any unit defined for the product, or empty for its base unit
+	Unit     string  `json:"unit" validate:"max=50"`
+	UnitCost float64 `json:"unit_cost" validate:"min=0"`
+}
+
+// purchaseOrderNotFound answers requests for purchase orders that do not exist
+var purchaseOrderNotFound = problem.NotFound("purchase_order_not_found", "Purchase order not found")
+
+// CreatePurchaseOrder orders stock from a supplier. Item quantities may be
+// given in any unit of their product and are converted into its base unit.
+func CreatePurchaseOrder(c *fiber.Ctx) error {
+	var requestData purchaseOrderRequest
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+	errs := validation.Struct(&requestData)
+	for i, item := range requestData.Items {
+		for _, invalid := range validation.Struct(&item) {
+			errs.Add(fmt.Sprintf("items.%d.%s", i, invalid.Field), invalid.Code, invalid.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return problem.Validation(errs)
+	}
+
+	ctx, idb := requestContext(c), tenantDB(c)
+	exists, err := idb.NewSelect().
+		Model((*models.Supplier)(nil)).
+		Where("id = ?", requestData.SupplierID).
+		Apply(tenancy.Scope(ctx)).
+		Exists(ctx)
+	if err != nil {
+		return problem.Internal(err, "Failed to create purchase order")
+	}
+	if !exists {
+		errs.Add("supplier_id", validation.NotFound, "Supplier does not exist")
+	}
+
+	order := models.PurchaseOrder{SupplierID: requestData.SupplierID, Status: models.PurchaseOrderOpen}
+	for i, item := range requestData.Items {
+		orderItem := models.PurchaseOrderItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Unit:      item.Unit,
+			UnitCost:  item.UnitCost,
+		}
+		err := setBaseQuantity(ctx, idb, item.ProductID, item.Unit, item.Quantity, &orderItem.BaseQuantity)
+		switch {
+		case errors.Is(err, errProductNotFound):
+			errs.Add(fmt.Sprintf("items.%d.product_id", i), validation.NotFound, "Product does not exist")
+		case errors.Is(err, errUnknownUnit):
+			errs.Add(fmt.Sprintf("items.%d.unit", i), validation.NotFound, err.Error())
+		case errors.Is(err, errFractionalQuantity):
+			errs.Add(fmt.Sprintf("items.%d.quantity", i), validation.Invalid, "Product is only stocked in whole base units")
+		case err != nil:
+			return problem.Internal(err, "Failed to create purchase order")
+		}
+		order.Items = append(order.Items, orderItem)
+	}
+	if len(errs) > 0 {
+		return problem.Validation(errs)
+	}
+
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(&order).Returning("*").Exec(ctx); err != nil {
+			return err
+		}
+		for i := range order.Items {
+			order.Items[i].PurchaseOrderID = order.ID
+		}
+		if _, err := tx.NewInsert().Model(&order.Items).Returning("*").Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, audit.PurchaseOrder, order.ID, nil, &order)
+	})
+	if err != nil {
+		return problem.Internal(err, "Failed to create purchase order")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+// ListPurchaseOrders returns the purchase orders with their items, newest first
+func ListPurchaseOrders(c *fiber.Ctx) error {
+	var orders []models.PurchaseOrder
+	err := tenantDB(c).NewSelect().
+		Model(&orders).
+		Relation("Items").
+		Order("po.created_at DESC").
+		Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch purchase orders")
+	}
+
+	if len(orders) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.PurchaseOrder{})
+	}
+	return c.Status(fiber.StatusOK).JSON(orders)
+}
+
+// GetPurchaseOrder returns a purchase order with its items
+func GetPurchaseOrder(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return purchaseOrderNotFound
+	}
+
+	var order models.PurchaseOrder
+	err = tenantDB(c).NewSelect().Model(&order).Relation("Items").Where("po.id = ?", id).Scan(requestContext(c))
+	if errors.Is(err, sql.ErrNoRows) {
+		return purchaseOrderNotFound
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch purchase order")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+// ReceivePurchaseOrder books the items of an open purchase order into stock
+func ReceivePurchaseOrder(c *fiber.Ctx) error {
+	return closePurchaseOrder(c, models.PurchaseOrderReceived, func(ctx context.Context, tx bun.Tx, order *models.PurchaseOrder) error {
+		for _, item := range order.Items {
+			if err := adjustStock(ctx, tx, item.ProductID, item.BaseQuantity); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		order.ReceivedAt = &now
+		return nil
+	})
+}
+
+// CancelPurchaseOrder cancels an open purchase order, leaving stock as it is
+func CancelPurchaseOrder(c *fiber.Ctx) error {
+	return closePurchaseOrder(c, models.PurchaseOrderCancelled, nil)
+}
+
+// closePurchaseOrder moves the open purchase order named by the id parameter
+// to status, running apply first, in one transaction with its audit entry
+func closePurchaseOrder(c *fiber.Ctx, status string, apply func(ctx context.Context, tx bun.Tx, order *models.PurchaseOrder) error) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return purchaseOrderNotFound
+	}
+
+	var order models.PurchaseOrder
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Locking the order makes concurrent requests wait, and then find it closed
+		err := tx.NewSelect().Model(&order).Where("po.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if order.Status != models.PurchaseOrderOpen {
+			return problem.Conflict("purchase_order_not_open", "Purchase order is not open").
+				Detailf("Purchase order is %s", order.Status).
+				With("status", order.Status)
+		}
+		err = tx.NewSelect().Model(&order.Items).Where("purchase_order_id = ?", id).Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		before := order
+		if apply != nil {
+			if err := apply(ctx, tx, &order); err != nil {
+				return err
+			}
+		}
+		order.Status = status
+		_, err = tx.NewUpdate().Model(&order).Column("status", "received_at").WherePK().Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.PurchaseOrder, order.ID, &before, &order)
+	})
+
+	var refusal *problem.Problem
+	if errors.As(err, &refusal) {
+		return refusal
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return purchaseOrderNotFound
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to update purchase order")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(order)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"github.com/google/uuid"
+)
+
+func TestPurchaseOrderReceive(t *testing.T) {
+	s := newDBServer(t)
+	productID, supplierID := s.stocked(5)
+
+	var order models.PurchaseOrder
+	status := s.do(http.MethodPost, "/purchase-orders", map[string]interface{}{
+		"supplier_id": supplierID,
+		"items":       []map[string]interface{}{{"product_id": productID, "quantity": 2, "unit": "case", "unit_cost": 12}},
+	}, &order)
+	if status != http.StatusCreated {
+		t.Fatalf("creating a purchase order answered %d, want 201", status)
+	}
+	if order.Status != models.PurchaseOrderOpen || len(order.Items) != 1 || order.Items[0].BaseQuantity != 48 {
+		t.Fatalf("created %+v, want an open order of 48 base units", order)
+	}
+	if quantity := s.stock(productID); quantity != 5 {
+		t.Errorf("ordering changed stock to %v, want 5", quantity)
+	}
+
+	path := "/purchase-orders/" + order.ID.String()
+	if status := s.do(http.MethodPost, path+"/receive", nil, &order); status != http.StatusOK {
+		t.Fatalf("receiving answered %d, want 200", status)
+	}
+	if order.Status != models.PurchaseOrderReceived || order.ReceivedAt == nil {
+		t.Errorf("received order is %s at %v", order.Status, order.ReceivedAt)
+	}
+	if quantity := s.stock(productID); quantity != 53 {
+		t.Errorf("receiving left %v in stock, want 53", quantity)
+	}
+
+	var failure problemBody
+	if status := s.do(http.MethodPost, path+"/receive", nil, &failure); status != http.StatusConflict || failure.Code != "purchase_order_not_open" {
+		t.Errorf("receiving twice answered %d %s, want 409 purchase_order_not_open", status, failure.Code)
+	}
+	if quantity := s.stock(productID); quantity != 53 {
+		t.Errorf("receiving twice left %v in stock, want 53", quantity)
+	}
+}
+
+func TestPurchaseOrderValidation(t *testing.T) {
+	s := newDBServer(t)
+	productID, _ := s.stocked(0)
+
+	var failure problemBody
+	status := s.do(http.MethodPost, "/purchase-orders", map[string]interface{}{
+		"supplier_id": uuid.New(),
+		"items":       []map[string]interface{}{{"product_id": productID, "quantity": 1, "unit": "pallet"}},
+	}, &failure)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid purchase order answered %d, want 422", status)
+	}
+	fields := map[string]bool{}
+	for _, invalid := range failure.Errors {
+		fields[invalid.Field] = true
+	}
+	if len(fields) != 2 || !fields["supplier_id"] || !fields["items.0.unit"] {
+		t.Errorf("invalid purchase order failed with %+v, want errors on supplier_id and items.0.unit", failure.Errors)
+	}
+	if status := s.do(http.MethodPost, "/purchase-orders/"+uuid.NewString()+"/cancel", nil, nil); status != http.StatusNotFound {
+		t.Errorf("cancelling a missing purchase order answered %d, want 404", status)
+	}
+}
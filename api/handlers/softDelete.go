@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/logging"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// PurgeConfig controls when soft-deleted rows are removed for good
+type PurgeConfig struct {
+	// Retention is how long a deleted row can still be restored
+	Retention time.Duration
+	// Interval is how often the purge job runs
+	Interval time.Duration
+}
+
+// includeDeleted reports whether a list should include soft-deleted rows,
+// which the request asks for with ?include_deleted=true. Only principals
+// allowed to delete the resource may see them; for anyone else it returns a
+// 403 problem.
+func includeDeleted(c *fiber.Ctx, permission string) (bool, error) {
+	if !c.QueryBool("include_deleted") {
+		return false, nil
+	}
+	if !auth.FromContext(c).Can(permission) {
+		return false, problem.Forbidden("forbidden", "Forbidden").WithDetail("Missing permission " + permission)
+	}
+	return true, nil
+}
+
+// RunPurgeJob purges expired soft-deleted rows every config.Interval until
+// ctx is done
+func RunPurgeJob(ctx context.Context, config PurgeConfig) {
+	ctx = logging.With(ctx, slog.String("job", "purge"))
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := PurgeDeleted(ctx, time.Now().Add(-config.Retention)); err != nil {
+			slog.ErrorContext(ctx, "Purge failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeleted hard-deletes the products, categories and suppliers of every
+// tenant that were deleted before cutoff and that nothing references any more.
+// Rows still referenced, for example by an order, are kept.
+func PurgeDeleted(ctx context.Context, cutoff time.Time) error {
+	var tenants []models.Tenant
+	if err := db.NewSelect().Model(&tenants).Scan(ctx); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tenant := range tenants {
+		if err := purgeTenant(ctx, tenant.ID, cutoff); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.Slug, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeTenant purges one tenant's expired rows in a single transaction, on a
+// connection scoped to the tenant so row-level security lets the job see them
+func purgeTenant(ctx context.Context, tenantID uuid.UUID, cutoff time.Time) error {
+	conn, err := tenantConn(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	defer releaseTenantConn(ctx, conn)
+
+	ctx = tenancy.WithTenant(ctx, tenantID)
+	ctx = audit.WithMetadata(ctx, audit.Metadata{ActorKind: audit.SystemActor})
+
+	var images []models.ProductImage
+	err = database.RunInTx(ctx, conn, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		images, err = purgeProducts(ctx, tx, cutoff)
+		if err != nil {
+			return err
+		}
+		if err := purgeCategories(ctx, tx, cutoff); err != nil {
+			return err
+		}
+		if err := purgeSuppliers(ctx, tx, cutoff); err != nil {
+			return err
+		}
+		// Expired idempotency keys are housekeeping of the same kind
+		_, err = tx.NewDelete().
+			Model((*models.IdempotencyKey)(nil)).
+			Where("expires_at < ?", time.Now()).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		deleteStoredImage(ctx, img)
+	}
+	return nil
+}
+
+// purgeProducts removes expired products that are neither in an order nor a
+// component of a kit, together with their units, images and bill of materials.
+// It returns the removed images, whose files are deleted once the transaction commits.
+func purgeProducts(ctx context.Context, tx bun.Tx, cutoff time.Time) ([]models.ProductImage, error) {
+	var products []models.Products
+	query := tx.NewSelect().
+		Model(&products).
+		WhereDeleted().
+		Where("?TableAlias.deleted_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM kit_components WHERE component_id = ?TableAlias.id)").
+		Where("NOT EXISTS (SELECT 1 FROM order_items WHERE product_id = ?TableAlias.id)")
+	if err := query.For("UPDATE").Scan(ctx); err != nil || len(products) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	var images []models.ProductImage
+	_, err := tx.NewDelete().
+		Model((*models.ProductImage)(nil)).
+		Where("product_id IN (?)", bun.In(ids)).
+		Returning("*").
+		Exec(ctx, &images)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.NewDelete().Model((*models.ProductUnit)(nil)).Where("product_id IN (?)", bun.In(ids)).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.NewDelete().Model((*models.KitComponent)(nil)).Where("kit_id IN (?)", bun.In(ids)).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		if _, err := tx.NewDelete().Model(&products[i]).WherePK().ForceDelete().Exec(ctx); err != nil {
+			return nil, err
+		}
+		if err := audit.Record(ctx, tx, audit.Purge, audit.Product, products[i].ID, &products[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// purgeCategories removes expired categories without products or
+// subcategories, deleted or not, together with their attribute definitions.
+// Removing a subcategory can free its parent, so it repeats until nothing is left.
+func purgeCategories(ctx context.Context, tx bun.Tx, cutoff time.Time) error {
+	for {
+		var categories []models.Category
+		err := tx.NewSelect().
+			Model(&categories).
+			WhereDeleted().
+			Where("?TableAlias.deleted_at < ?", cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM products WHERE category_id = ?TableAlias.id)").
+			Where("NOT EXISTS (SELECT 1 FROM categories child WHERE child.parent_id = ?TableAlias.id)").
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil || len(categories) == 0 {
+			return err
+		}
+
+		for i := range categories {
+			_, err := tx.NewDelete().
+				Model((*models.CategoryAttribute)(nil)).
+				Where("category_id = ?", categories[i].ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.NewDelete().Model(&categories[i]).WherePK().ForceDelete().Exec(ctx); err != nil {
+				return err
+			}
+			if err := audit.Record(ctx, tx, audit.Purge, audit.Category, categories[i].ID, &categories[i], nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// purgeSuppliers removes expired suppliers no product refers to, deleted or not
+func purgeSuppliers(ctx context.Context, tx bun.Tx, cutoff time.Time) error {
+	var suppliers []models.Supplier
+	err := tx.NewSelect().
+		Model(&suppliers).
+		WhereDeleted().
+		Where("?TableAlias.deleted_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM products WHERE supplier_id = ?TableAlias.id)").
+		For("UPDATE").
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range suppliers {
+		if _, err := tx.NewDelete().Model(&suppliers[i]).WherePK().ForceDelete().Exec(ctx); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Purge, audit.Supplier, suppliers[i].ID, &suppliers[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateCannotDelete(t *testing.T) {
+	s := newTestServer(t)
+	tools := s.category("Tools", nil)
+	acme := s.supplier("Acme")
+	hammer := s.product("Hammer", tools.ID, acme.ID)
+	deletedAt := time.Now().UTC()
+
+	// Deleting takes its own permission and checks, which PUT must not get around
+	tests := []struct {
+		name string
+		path string
+		body map[string]interface{}
+		get  func(ctx context.Context) error
+	}{
+		{"category", "/categories/" + tools.ID.String(),
+			map[string]interface{}{"Name": "Tools", "DeletedAt": deletedAt},
+			func(ctx context.Context) error { _, err := s.repos.Categories.Get(ctx, tools.ID); return err }},
+		{"supplier", "/suppliers/" + acme.ID.String(),
+			map[string]interface{}{"Name": "Acme", "DeletedAt": deletedAt},
+			func(ctx context.Context) error { _, err := s.repos.Suppliers.Get(ctx, acme.ID); return err }},
+		{"product", "/products/" + hammer.ID.String(),
+			map[string]interface{}{"Name": "Hammer", "CategoryID": tools.ID, "SupplierID": acme.ID, "BaseUnit": "each", "DeletedAt": deletedAt},
+			func(ctx context.Context) error { _, err := s.repos.Products.Get(ctx, hammer.ID); return err }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := s.do(http.MethodPut, test.path, test.body, nil); status != http.StatusOK {
+				t.Fatalf("update answered %d, want 200", status)
+			}
+			if err := test.get(s.ctx()); err != nil {
+				t.Errorf("updated row is gone: %v", err)
+			}
+		})
+	}
+}
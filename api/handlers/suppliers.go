@@ -1,182 +1,195 @@
-package handlers
-
-import (
-	"fmt"
-	"log"
-	"strings"
-
-	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
-	"github.com/gofiber/fiber/v2"
-)
-
-// Get all suppliers
-func GetAllSuppliers(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-	
-	_, err := db.NewCreateTable().Model(&models.Supplier{}).IfNotExists().Exec(dbCtx)
-	
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "An unexpected error occurred",
-		})
-	}
-
-	var suppliers []models.Supplier
-	err = db.NewSelect().Model(&suppliers).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	if len(suppliers) == 0 {
-		return c.Status(fiber.StatusNoContent).JSON([]models.Supplier{})
-	}
-	return c.Status(fiber.StatusOK).JSON(suppliers)
-}
-
-func CreateSupplier(c *fiber.Ctx) error {
-	var supplier models.Supplier
-	if err := c.BodyParser(&supplier); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
-	}
-
-	// Validate required fields
-	if supplier.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Validation failed",
-			"details": "Supplier name is required and cannot be empty",
-		})
-	}
-
-	// Check if supplier with same name or email already exists
-	var existingSupplier models.Supplier
-	err := db.NewSelect().
-		Model(&existingSupplier).
-		Where("LOWER(name) = LOWER(?)", supplier.Name).
-		WhereOr("LOWER(email) = LOWER(?)", supplier.Email).
-		Scan(dbCtx)
-	
-	if err == nil {
-		// Determine which field caused the conflict
-		field := "name"
-		if strings.EqualFold(existingSupplier.Email, supplier.Email) {
-			field = "email"
-		}
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "Duplicate entry",
-			"details": fmt.Sprintf("A supplier with this %s already exists", field),
-			"field": field,
-		})
-	}
-
-	_, err = db.NewInsert().Model(&supplier).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Full Database Error: %+v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Database operation failed",
-			"details": "Failed to create supplier. Please try again later",
-		})
-	}
-
-	return c.Status(fiber.StatusCreated).JSON(supplier)
-}
-
-func GetOneSupplier(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var supplier models.Supplier
-
-	err = db.NewSelect().Model(&supplier).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Supplier not found",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(supplier)
-}
-
-func UpdateSupplier(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-	var originalSupplier models.Supplier
-
-	// Check if supplier exists
-	err = db.NewSelect().Model(&originalSupplier).Where("id = ?", id).Scan(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Supplier not found",
-		})
-	}
-
-	var supplier models.Supplier
-	if err := c.BodyParser(&supplier); err != nil {
-		log.Printf("Parse Error: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
-	}
-
-	// Validate required fields
-	if supplier.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Validation failed",
-			"details": "Supplier name is required and cannot be empty",
-		})
-	}
-
-	_, err = db.NewUpdate().Model(&supplier).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update supplier",
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(supplier)
-}
-
-func DeleteSupplier(c *fiber.Ctx) error {
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return err
-	}
-
-	id := c.Params("id")
-
-	result, err := db.NewDelete().Model((*models.Supplier)(nil)).Where("id = ?", id).Exec(dbCtx)
-	if err != nil {
-		log.Printf("Database Error: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to delete supplier",
-		})
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Supplier not found",
-		})
-	}
-
-	return c.SendStatus(fiber.StatusNoContent)
-}
\ No newline at end of file
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// SupplierHandler serves the supplier endpoints from its repositories
+type SupplierHandler struct {
+	suppliers repository.SupplierRepository
+	products  repository.ProductRepository
+	tx        repository.Transactor
+}
+
+// NewSupplierHandler returns a SupplierHandler that uses repos
+func NewSupplierHandler(repos repository.Repositories) *SupplierHandler {
+	return &SupplierHandler{suppliers: repos.Suppliers, products: repos.Products, tx: repos.Tx}
+}
+
+// List returns all suppliers
+func (h *SupplierHandler) List(c *fiber.Ctx) error {
+	withDeleted, err := includeDeleted(c, auth.SuppliersDelete)
+	if err != nil {
+		return err
+	}
+	suppliers, err := h.suppliers.List(requestContext(c), withDeleted)
+	if err != nil {
+		return err
+	}
+
+	if len(suppliers) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.Supplier{})
+	}
+	return c.Status(fiber.StatusOK).JSON(suppliers)
+}
+
+func (h *SupplierHandler) Create(c *fiber.Ctx) error {
+	var supplier models.Supplier
+	if err := c.BodyParser(&supplier); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	if errs := validation.Struct(&supplier); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// The unique indexes on name and email refuse duplicates
+	err := h.suppliers.Create(requestContext(c), &supplier)
+	if failure := databaseErrorResponse(err, "Supplier"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to create supplier")
+	}
+
+	return sendVersioned(c, fiber.StatusCreated, supplier.Version, supplier)
+}
+
+func (h *SupplierHandler) Get(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+
+	supplier, err := h.suppliers.Get(requestContext(c), id)
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, supplier.Version, supplier)
+}
+
+func (h *SupplierHandler) Update(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+
+	// Check if supplier exists
+	if _, err := h.suppliers.Get(requestContext(c), id); err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+
+	var supplier models.Supplier
+	if err := c.BodyParser(&supplier); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	if errs := validation.Struct(&supplier); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// Request bodies usually leave the ID out; the supplier keeps its own
+	supplier.ID = id
+	err = h.suppliers.Update(requestContext(c), &supplier, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if failure := databaseErrorResponse(err, "Supplier"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to update supplier")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, supplier.Version, supplier)
+}
+
+// Patch changes only the fields named in a merge patch or JSON Patch
+func (h *SupplierHandler) Patch(c *fiber.Ctx) error {
+	supplier, err := patchResource(c, h.suppliers.Patch, validateSupplier)
+	if err != nil {
+		return patchErrorResponse(c, err, "Supplier")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, supplier.Version, supplier)
+}
+
+// validateSupplier checks a patched or new supplier. Duplicate names and
+// emails are left to the unique indexes, like in Create.
+func validateSupplier(ctx context.Context, before, after *models.Supplier) error {
+	if errs := validation.Struct(after); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (h *SupplierHandler) Delete(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+
+	// A deleted supplier would disappear from its products, so they must move first
+	products, err := h.products.Count(requestContext(c), repository.ProductFilter{SupplierID: id})
+	if err != nil {
+		return problem.Internal(err, "Failed to delete supplier")
+	}
+	if products > 0 {
+		return problem.Conflict("supplier_has_products", "Supplier has products").
+			Detailf("Supplier still supplies %d products; assign them to another supplier first", products).
+			With("products", products)
+	}
+
+	err = h.suppliers.Delete(requestContext(c), id, ifMatch(c))
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("supplier_not_found", "Supplier not found")
+	}
+	if isPreconditionError(err) {
+		return preconditionErrorResponse(c, err)
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete supplier")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Restore undoes the deletion of a supplier
+func (h *SupplierHandler) Restore(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.NotFound("deleted_supplier_not_found", "Deleted supplier not found")
+	}
+
+	supplier, err := h.suppliers.Restore(requestContext(c), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return problem.NotFound("deleted_supplier_not_found", "Deleted supplier not found")
+	}
+	// Another supplier may have taken the name or email in the meantime
+	if failure := databaseErrorResponse(err, "Supplier"); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to restore supplier")
+	}
+
+	return sendVersioned(c, fiber.StatusOK, supplier.Version, supplier)
+}
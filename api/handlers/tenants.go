@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// tenantConnKey is the fiber.Ctx Locals key TenantScope stores the request's connection under
+const tenantConnKey = "tenantConn"
+
+// tenantSlugPattern keeps slugs usable in URLs and log lines
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// TenantScope runs the rest of the request on a dedicated connection with
+// app.tenant_id set to the principal's tenant, so the row-level security
+// policies only expose that tenant's rows. It must run after auth.Authenticate.
+func TenantScope(c *fiber.Ctx) error {
+	principal := auth.FromContext(c)
+	if principal == nil {
+		return problem.Unauthorized("authentication_required", "Authentication required")
+	}
+
+	ctx := c.UserContext()
+	conn, err := tenantConn(ctx, principal.TenantID)
+	if database.IsTimeout(err) {
+		// Every connection stayed busy until the deadline
+		return problem.Unavailable("database_unavailable", "Database unavailable").WithDetail("No database connection became free in time; try again")
+	}
+	if err != nil {
+		return problem.Internal(err, "Database connection failed")
+	}
+	// The connection is reset even when the request ran out of time
+	defer releaseTenantConn(context.WithoutCancel(ctx), conn)
+
+	c.Locals(tenantConnKey, &conn)
+	return c.Next()
+}
+
+// tenantConn takes a connection from the pool with app.tenant_id set to a
+// tenant and its statements limited to ctx's deadline. It must be returned
+// with releaseTenantConn.
+func tenantConn(ctx context.Context, tenantID uuid.UUID) (bun.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return conn, err
+	}
+	_, err = conn.ExecContext(ctx, "SELECT set_config(?, ?, false)", tenancy.SettingName, tenantID.String())
+	if err == nil {
+		err = limitStatements(ctx, conn)
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return conn, err
+}
+
+// releaseTenantConn clears app.tenant_id and the statement timeout and
+// returns the connection to the pool
+func releaseTenantConn(ctx context.Context, conn bun.Conn) {
+	if _, err := conn.ExecContext(ctx, "RESET "+tenancy.SettingName+"; RESET statement_timeout"); err != nil {
+		// Never hand a connection still scoped to this tenant to another request
+		slog.ErrorContext(ctx, "Failed to reset tenant connection", "error", err)
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// tenantDB returns the request's tenant connection. Without TenantScope it
+// falls back to the shared pool, where row-level security hides every row.
+func tenantDB(c *fiber.Ctx) bun.IDB {
+	if conn, ok := c.Locals(tenantConnKey).(*bun.Conn); ok {
+		return conn
+	}
+	return db
+}
+
+// requestContext returns the request's context, which is canceled at its
+// deadline (see Deadline). Its queries on tenant-owned models are limited to
+// the principal's tenant, and it carries the principal and request ID for the
+// audit log. Repositories given it run their queries on the request's tenant
+// connection.
+func requestContext(c *fiber.Ctx) context.Context {
+	ctx := c.UserContext()
+	principal := auth.FromContext(c)
+	if principal == nil {
+		return ctx
+	}
+	ctx = tenancy.WithTenant(ctx, principal.TenantID)
+	ctx = database.WithIDB(ctx, tenantDB(c))
+	return withAuditMetadata(ctx, c, principal)
+}
+
+// runInTx runs fn in a transaction on the request's tenant connection. It is
+// retried if Postgres aborts it for conflicting with a concurrent one, and
+// database errors come back translated, as from database.RunInTx.
+func runInTx(c *fiber.Ctx, opts *sql.TxOptions, fn func(ctx context.Context, tx bun.Tx) error) error {
+	return database.RunInTx(requestContext(c), tenantDB(c), opts, fn)
+}
+
+// Get all tenants
+func GetAllTenants(c *fiber.Ctx) error {
+	var tenants []models.Tenant
+	err := tenantDB(c).NewSelect().Model(&tenants).Order("name").Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch tenants")
+	}
+
+	if len(tenants) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.Tenant{})
+	}
+	return c.Status(fiber.StatusOK).JSON(tenants)
+}
+
+// CreateTenant creates a tenant together with its first admin account
+func CreateTenant(c *fiber.Ctx) error {
+	var requestData struct {
+		Name          string `json:"name" validate:"required,max=255"`
+		Slug          string `json:"slug" validate:"required,max=63"`
+		AdminUsername string `json:"admin_username" validate:"required,max=255"`
+		AdminPassword string `json:"admin_password" validate:"required"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	requestData.Name = strings.TrimSpace(requestData.Name)
+	requestData.AdminUsername = strings.TrimSpace(requestData.AdminUsername)
+	errs := validation.Struct(&requestData)
+	if !errs.Has("slug") && !tenantSlugPattern.MatchString(requestData.Slug) {
+		errs.Add("slug", validation.Invalid, "slug may only contain lowercase letters, digits and hyphens")
+	}
+	checkPassword("admin_password", requestData.AdminPassword, &errs)
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	hash, err := auth.HashPassword(requestData.AdminPassword)
+	if err != nil {
+		return problem.Internal(err, "Failed to create tenant")
+	}
+
+	tenant := models.Tenant{Name: requestData.Name, Slug: requestData.Slug}
+	admin := models.User{
+		Username:     requestData.AdminUsername,
+		PasswordHash: hash,
+		Role:         auth.RoleAdmin,
+		Active:       true,
+	}
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		exists, err := tx.NewSelect().Model((*models.Tenant)(nil)).Where("slug = ?", tenant.Slug).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return problem.Conflict("duplicate_entry", "Duplicate entry").
+				Detailf("A tenant with slug '%s' already exists", tenant.Slug).
+				With("field", "slug")
+		}
+		exists, err = tx.NewSelect().
+			Model((*models.User)(nil)).
+			Where("LOWER(username) = LOWER(?)", admin.Username).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return problem.Conflict("duplicate_entry", "Duplicate entry").
+				Detailf("A user named '%s' already exists", admin.Username).
+				With("field", "admin_username")
+		}
+
+		if _, err := tx.NewInsert().Model(&tenant).Returning("*").Exec(ctx); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.Create, audit.Tenant, tenant.ID, nil, &tenant); err != nil {
+			return err
+		}
+		admin.TenantID = tenant.ID
+		_, err = tx.NewInsert().Model(&admin).Returning("*").Exec(ctx)
+		return err
+	})
+	var conflict *problem.Problem
+	if errors.As(err, &conflict) {
+		return conflict
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to create tenant")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"tenant": tenant,
+		"admin":  admin,
+	})
+}
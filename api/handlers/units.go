@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+var (
+	errUnknownUnit        = errors.New("unknown unit")
+	errFractionalQuantity = errors.New("fractional quantity")
+)
+
+// quantityTolerance absorbs float rounding when checking for whole numbers
+const quantityTolerance = 1e-9
+
+// isWholeQuantity reports whether a quantity has no fractional part
+func isWholeQuantity(quantity float64) bool {
+	return math.Abs(quantity-math.Round(quantity)) < quantityTolerance
+}
+
+// toBaseUnit converts a quantity given in unit into the product's base unit.
+// An empty unit, or the base unit itself, needs no conversion. A unit the
+// product does not define is errUnknownUnit; failing to look it up is not.
+func toBaseUnit(ctx context.Context, idb bun.IDB, product *models.Products, unit string, quantity float64) (float64, error) {
+	base := quantity
+	if unit != "" && !strings.EqualFold(unit, product.BaseUnit) {
+		var productUnit models.ProductUnit
+		err := idb.NewSelect().
+			Model(&productUnit).
+			Where("product_id = ? AND LOWER(name) = LOWER(?)", product.ID, unit).
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %s", errUnknownUnit, unit)
+		}
+		if err != nil {
+			return 0, err
+		}
+		base = quantity * productUnit.Factor
+	}
+
+	if !product.Fractional {
+		if !isWholeQuantity(base) {
+			return 0, fmt.Errorf("%w: %g %s", errFractionalQuantity, base, product.BaseUnit)
+		}
+		base = math.Round(base)
+	}
+	return base, nil
+}
+
+// unitErrorResponse maps unit conversion errors to a response
+func unitErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errUnknownUnit):
+		return validationErrorResponse(c, validationError("unit", validation.NotFound, err.Error()))
+	case errors.Is(err, errFractionalQuantity):
+		return validationErrorResponse(c, validationError("quantity", validation.Invalid, "Product is only stocked in whole base units"))
+	}
+
+	return problem.Internal(err, "Failed to convert quantity")
+}
+
+// GetProductUnits lists the units a product can be handled in
+func GetProductUnits(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var product models.Products
+	err := tenantDB(c).NewSelect().Model(&product).Where("id = ?", id).Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	var units []models.ProductUnit
+	err = tenantDB(c).NewSelect().Model(&units).Where("product_id = ?", product.ID).Order("factor").Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch units")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"base_unit":  product.BaseUnit,
+		"fractional": product.Fractional,
+		"units":      units,
+	})
+}
+
+// CreateProductUnit adds a unit with its conversion factor to a product
+func CreateProductUnit(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var product models.Products
+	err := tenantDB(c).NewSelect().Model(&product).Where("id = ?", id).Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+
+	var requestData struct {
+		Name   string  `json:"name" validate:"required,max=50"`
+		Factor float64 `json:"factor" validate:"gt=0"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	requestData.Name = strings.TrimSpace(requestData.Name)
+	errs := validation.Struct(&requestData)
+	if strings.EqualFold(requestData.Name, product.BaseUnit) {
+		errs.Add("name", validation.Invalid, "Unit name must differ from the base unit")
+	}
+	if !errs.Has("factor") && !product.Fractional && !isWholeQuantity(requestData.Factor) {
+		errs.Add("factor", validation.Invalid, "Factor must be a whole number of base units unless the product is fractional")
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	exists, err := tenantDB(c).NewSelect().
+		Model((*models.ProductUnit)(nil)).
+		Where("product_id = ? AND LOWER(name) = LOWER(?)", product.ID, requestData.Name).
+		Apply(tenancy.Scope(requestContext(c))).
+		Exists(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to create unit")
+	}
+	if exists {
+		return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("Product already has a unit named '%s'", requestData.Name).With("field", "name")
+	}
+
+	unit := models.ProductUnit{
+		ProductID: product.ID,
+		Name:      requestData.Name,
+		Factor:    requestData.Factor,
+	}
+	err = insertAudited(c, audit.ProductUnit, &unit)
+	if err != nil {
+		return problem.Internal(err, "Failed to create unit")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(unit)
+}
+
+// DeleteProductUnit removes a unit from a product
+func DeleteProductUnit(c *fiber.Ctx) error {
+	err := runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		var unit models.ProductUnit
+		_, err := tx.NewDelete().
+			Model(&unit).
+			Where("id = ? AND product_id = ?", c.Params("unitId"), c.Params("id")).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if unit.ID == uuid.Nil {
+			return sql.ErrNoRows
+		}
+		return audit.Record(ctx, tx, audit.Delete, audit.ProductUnit, unit.ID, &unit, nil)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("unit_not_found", "Unit not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete unit")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ReceiveStock books a receipt of goods, given in any of the product's units, into stock
+func ReceiveStock(c *fiber.Ctx) error {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return problem.BadRequest("invalid_product_id", "Invalid product ID format").WithDetail(err.Error())
+	}
+
+	var requestData struct {
+		Quantity float64 `json:"quantity" validate:"gt=0"`
+		Unit     string  `json:"unit"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+	if errs := validation.Struct(&requestData); len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	var product models.Products
+	var received float64
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(&product).Where("id = ?", productID).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		received, err = toBaseUnit(ctx, tx, &product, requestData.Unit, requestData.Quantity)
+		if err != nil {
+			return err
+		}
+
+		product.Quantity += received
+		return adjustStock(ctx, tx, product.ID, received)
+	})
+
+	if errors.Is(err, errUnknownUnit) || errors.Is(err, errFractionalQuantity) {
+		return unitErrorResponse(c, err)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("product_not_found", "Product not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to receive stock")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"product_id": product.ID,
+		"received":   received,
+		"base_unit":  product.BaseUnit,
+		"quantity":   product.Quantity,
+	})
+}
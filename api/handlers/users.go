@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/uptrace/bun"
+)
+
+// minPasswordLength is the shortest password accepted for an account
+const minPasswordLength = 8
+
+// assignableRole reports whether the principal may give an account a role.
+// Superadmins can manage every tenant, so only they can create more.
+func assignableRole(c *fiber.Ctx, role string) bool {
+	if role == auth.RoleSuperadmin {
+		return auth.FromContext(c).Can(auth.TenantsManage)
+	}
+	return auth.ValidRole(role)
+}
+
+// checkRole records an error unless the principal may give an account the role
+func checkRole(c *fiber.Ctx, role string, errs *validation.Errors) {
+	if role != "" && !assignableRole(c, role) {
+		errs.Add("role", validation.OneOf, "Role must be admin, manager, clerk or viewer")
+	}
+}
+
+// checkPassword records an error for a password that is too short
+func checkPassword(field, password string, errs *validation.Errors) {
+	if password != "" && len(password) < minPasswordLength {
+		errs.Add(field, validation.Min, fmt.Sprintf("%s must be at least %d characters", field, minPasswordLength))
+	}
+}
+
+// protectSuperadmin refuses changes to a superadmin's account unless the
+// principal is a superadmin too, so an admin cannot lock one out
+func protectSuperadmin(c *fiber.Ctx, user *models.User) error {
+	if user.Role == auth.RoleSuperadmin && !auth.FromContext(c).Can(auth.TenantsManage) {
+		return problem.Forbidden("forbidden", "Forbidden").WithDetail("Only a superadmin can change a superadmin's account")
+	}
+	return nil
+}
+
+// revokeRefreshTokens ends every session of a user
+func revokeRefreshTokens(ctx context.Context, idb bun.IDB, userID interface{}) error {
+	_, err := idb.NewUpdate().
+		Model((*models.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Exec(ctx)
+	return err
+}
+
+// GetCurrentUser returns the account of the authenticated user
+func GetCurrentUser(c *fiber.Ctx) error {
+	var user models.User
+	err := tenantDB(c).NewSelect().Model(&user).Where("id = ?", auth.FromContext(c).ID).Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("user_not_found", "User not found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+// Get all users
+func GetAllUsers(c *fiber.Ctx) error {
+	var users []models.User
+	err := tenantDB(c).NewSelect().
+		Model(&users).
+		Where("tenant_id = ?", auth.FromContext(c).TenantID).
+		Order("username").
+		Scan(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to fetch users")
+	}
+
+	if len(users) == 0 {
+		return c.Status(fiber.StatusNoContent).JSON([]models.User{})
+	}
+	return c.Status(fiber.StatusOK).JSON(users)
+}
+
+// Create a new user
+func CreateUser(c *fiber.Ctx) error {
+	var requestData struct {
+		Username string `json:"username" validate:"required,max=255"`
+		Password string `json:"password" validate:"required"`
+		Role     string `json:"role" validate:"required"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	requestData.Username = strings.TrimSpace(requestData.Username)
+	errs := validation.Struct(&requestData)
+	checkPassword("password", requestData.Password, &errs)
+	checkRole(c, requestData.Role, &errs)
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	// Usernames are unique across tenants, since logging in names no tenant
+	exists, err := tenantDB(c).NewSelect().
+		Model((*models.User)(nil)).
+		Where("LOWER(username) = LOWER(?)", requestData.Username).
+		Exists(requestContext(c))
+	if err != nil {
+		return problem.Internal(err, "Failed to create user")
+	}
+	if exists {
+		return problem.Conflict("duplicate_entry", "Duplicate entry").Detailf("A user named '%s' already exists", requestData.Username).With("field", "username")
+	}
+
+	hash, err := auth.HashPassword(requestData.Password)
+	if err != nil {
+		return problem.Internal(err, "Failed to create user")
+	}
+
+	user := models.User{
+		TenantID:     auth.FromContext(c).TenantID,
+		Username:     requestData.Username,
+		PasswordHash: hash,
+		Role:         requestData.Role,
+		Active:       true,
+	}
+	err = insertAudited(c, audit.User, &user)
+	if err != nil {
+		return problem.Internal(err, "Failed to create user")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// Update a user's role, password or active flag. Changing the password or
+// disabling the account ends its existing sessions.
+func UpdateUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var user models.User
+	err := tenantDB(c).NewSelect().
+		Model(&user).
+		Where("id = ? AND tenant_id = ?", id, auth.FromContext(c).TenantID).
+		Scan(requestContext(c))
+	if err != nil {
+		logLookupError(c.UserContext(), err)
+		return problem.NotFound("user_not_found", "User not found")
+	}
+	if err := protectSuperadmin(c, &user); err != nil {
+		return err
+	}
+	before := user
+
+	var requestData struct {
+		Password *string `json:"password"`
+		Role     *string `json:"role"`
+		Active   *bool   `json:"active"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		slog.DebugContext(c.UserContext(), "Invalid request", "error", err)
+		return problem.BadRequest("invalid_request_body", "Invalid request body").WithDetail(err.Error())
+	}
+
+	var errs validation.Errors
+	if requestData.Role != nil {
+		checkRole(c, *requestData.Role, &errs)
+	}
+	if requestData.Password != nil {
+		checkPassword("password", *requestData.Password, &errs)
+	}
+	if len(errs) > 0 {
+		return validationErrorResponse(c, errs)
+	}
+
+	revokeSessions := false
+	if requestData.Role != nil {
+		user.Role = *requestData.Role
+	}
+	if requestData.Password != nil {
+		user.PasswordHash, err = auth.HashPassword(*requestData.Password)
+		if err != nil {
+			return problem.Internal(err, "Failed to update user")
+		}
+		revokeSessions = true
+	}
+	if requestData.Active != nil {
+		user.Active = *requestData.Active
+		revokeSessions = revokeSessions || !user.Active
+	}
+
+	err = runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().Model(&user).Column("role", "password_hash", "active").WherePK().Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if revokeSessions {
+			if err := revokeRefreshTokens(ctx, tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return audit.Record(ctx, tx, audit.Update, audit.User, user.ID, &before, &user)
+	})
+	if err != nil {
+		return problem.Internal(err, "Failed to update user")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+// Delete a user
+func DeleteUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if id == auth.FromContext(c).ID.String() {
+		return problem.BadRequest("cannot_delete_self", "You cannot delete your own account")
+	}
+
+	var user models.User
+	err := runInTx(c, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&user).
+			Where("id = ? AND tenant_id = ?", id, auth.FromContext(c).TenantID).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := protectSuperadmin(c, &user); err != nil {
+			return err
+		}
+		if _, err := tx.NewDelete().Model(&user).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, audit.User, user.ID, &user, nil)
+	})
+	var refusal *problem.Problem
+	if errors.As(err, &refusal) {
+		return refusal
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return problem.NotFound("user_not_found", "User not found")
+	}
+	if err != nil {
+		return problem.Internal(err, "Failed to delete user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
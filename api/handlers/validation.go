@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// validationError returns a single failed check as an error
+func validationError(field, code, message string) error {
+	return validation.Errors{{Field: field, Code: code, Message: message}}
+}
+
+// validationErrorResponse answers 422 listing every field that failed
+// validation. Any other error is a failure to validate, such as a database error.
+func validationErrorResponse(c *fiber.Ctx, err error) error {
+	var invalid validation.Errors
+	if errors.As(err, &invalid) {
+		return problem.Validation(invalid)
+	}
+
+	return problem.Internal(err, "Failed to validate request")
+}
+
+// checkExists records a not_found error for field unless lookup finds the
+// given ID in the caller's tenant, such as a repository's Exists. Zero IDs
+// are left to the required rule.
+func checkExists(ctx context.Context, lookup func(ctx context.Context, id uuid.UUID) (bool, error), id uuid.UUID, field, name string, errs *validation.Errors) error {
+	if id == uuid.Nil {
+		return nil
+	}
+	exists, err := lookup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		errs.Add(field, validation.NotFound, name+" not found")
+	}
+	return nil
+}
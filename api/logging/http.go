@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Middleware adds the request's ID, set by the requestid middleware, to every
+// record logged with the request's context, and writes an access log record
+// once the request has been answered. Errors are sent by the app's error
+// handler here, so that their status is logged.
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		c.SetUserContext(With(c.UserContext(), slog.String("request_id", requestID)))
+	}
+
+	err := c.Next()
+	route := c.Route().Path
+	// The router answers requests no route matched with a fiber.Error
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound {
+		route = ""
+	}
+	if err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	status := c.Response().StatusCode()
+	// The cause of a server error is logged on its own as an error
+	level := slog.LevelInfo
+	if status >= fiber.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	// The handlers may have replaced the context, for example with a traced one
+	slog.Log(c.UserContext(), level, "Request",
+		slog.String("method", c.Method()),
+		slog.String("path", c.Path()),
+		slog.String("route", route),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", bodySize(c.Response())),
+		slog.String("ip", c.IP()),
+		slog.String("user_agent", string(c.Request().Header.UserAgent())),
+	)
+	return nil
+}
+
+// bodySize returns the size of a response body without reading streamed
+// ones, such as files, whose size is only known from their header
+func bodySize(response *fasthttp.Response) int {
+	if response.IsBodyStream() {
+		return response.Header.ContentLength()
+	}
+	return len(response.Body())
+}
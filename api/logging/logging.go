@@ -0,0 +1,58 @@
+// Package logging writes the API's log as structured records with log/slog.
+//
+// Records carry the attributes stored in their context, such as the ID of the
+// request they were logged for, and values of sensitive attributes are
+// redacted. The level can be changed while the API runs.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// Level is the lowest level logged. It can be changed at any time.
+var Level = new(slog.LevelVar)
+
+// NewHandler returns a handler writing records to w as JSON or, for any other
+// format, as text
+func NewHandler(w io.Writer, format string) slog.Handler {
+	options := &slog.HandlerOptions{Level: Level, ReplaceAttr: redact}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return contextHandler{handler}
+}
+
+// attrsKey holds the attributes added to every record logged with a context
+type attrsKey struct{}
+
+// With returns a context whose records carry attrs, along with those of ctx
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(existing)+len(attrs))
+	combined = append(append(combined, existing...), attrs...)
+	return context.WithValue(ctx, attrsKey{}, combined)
+}
+
+// contextHandler adds the attributes of a record's context to the record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
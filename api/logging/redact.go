@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces the values that must not be logged
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attributes whose values are never logged, compared
+// without case. Keys ending in one of them, such as supplier_email, are
+// redacted too.
+var sensitiveKeys = []string{
+	"authorization",
+	"cookie",
+	"email",
+	"password",
+	"secret",
+	"token",
+	"x-api-key",
+	"api_key",
+}
+
+// emailAddress matches email addresses in messages and errors, such as the
+// detail of a unique violation on a supplier's email
+var emailAddress = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// redact hides the values of sensitive attributes and the email addresses in
+// the others
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if sensitive(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(redactText(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(redactText(err.Error()))
+		}
+	}
+	return attr
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range sensitiveKeys {
+		if strings.HasSuffix(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactText(text string) string {
+	return emailAddress.ReplaceAllString(text, redacted)
+}
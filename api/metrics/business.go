@@ -0,0 +1,45 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	stockValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stock_value",
+		Help:      "Value of the stock at current prices, by tenant.",
+	}, []string{"tenant"})
+
+	productsBelowReorderPoint = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "products_below_reorder_point",
+		Help:      "Products whose stock is at or below their reorder point, by tenant.",
+	}, []string{"tenant"})
+
+	orders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orders",
+		Help:      "Orders, by tenant and status.",
+	}, []string{"tenant", "status"})
+)
+
+// KPIs are the stock and order figures of one tenant
+type KPIs struct {
+	StockValue                float64
+	ProductsBelowReorderPoint int
+	OrdersByStatus            map[string]int
+}
+
+// SetKPIs replaces the stock and order figures with those of tenants, keyed
+// by tenant slug. Tenants left out are no longer exported.
+func SetKPIs(tenants map[string]KPIs) {
+	stockValue.Reset()
+	productsBelowReorderPoint.Reset()
+	orders.Reset()
+	for tenant, kpis := range tenants {
+		stockValue.WithLabelValues(tenant).Set(kpis.StockValue)
+		productsBelowReorderPoint.WithLabelValues(tenant).Set(float64(kpis.ProductsBelowReorderPoint))
+		for status, count := range kpis.OrdersByStatus {
+			orders.WithLabelValues(tenant, status).Set(float64(count))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/uptrace/bun"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database queries, by operation such as SELECT or INSERT.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Database queries that failed, by operation. Queries that find no rows are not counted.",
+	}, []string{"operation"})
+)
+
+// Instrument times the queries run through db and exports the statistics of
+// its connection pool
+func Instrument(db *bun.DB) {
+	db.AddQueryHook(queryHook{})
+	Registry.MustRegister(collectors.NewDBStatsCollector(db.DB, namespace))
+}
+
+// queryHook records the duration and outcome of every query
+type queryHook struct{}
+
+func (queryHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (queryHook) AfterQuery(_ context.Context, event *bun.QueryEvent) {
+	operation := event.Operation()
+	queryDuration.WithLabelValues(operation).Observe(time.Since(event.StartTime).Seconds())
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		queryErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests that no route matched, so that scanners
+// probing random paths do not add a series per path
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests answered, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer requests, by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Middleware counts and times every request. Requests are labeled with the
+// path of the route that answered them, such as /products/:id, rather than
+// the path requested. Errors are sent by the app's error handler here, so that
+// their status code is recorded.
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	route := c.Route().Path
+	// The router answers requests no route matched with a fiber.Error; the
+	// handlers report their own not found problems
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound {
+		route = unmatchedRoute
+	}
+	if err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	labels := prometheus.Labels{
+		"method": c.Method(),
+		"route":  route,
+		"status": strconv.Itoa(c.Response().StatusCode()),
+	}
+	httpRequests.With(labels).Inc()
+	httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package metrics collects the Prometheus metrics of the API: requests,
+// database queries and connections, and stock and order figures.
+package metrics
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the API's own metrics
+const namespace = "ims"
+
+// Registry holds every metric the API exports, along with those of the Go
+// runtime and the process
+var Registry = func() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		queryDuration,
+		queryErrors,
+		stockValue,
+		productsBelowReorderPoint,
+		orders,
+	)
+	return registry
+}()
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
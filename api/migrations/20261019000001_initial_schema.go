@@ -0,0 +1,208 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/uptrace/bun"
+)
+
+// The schema as it was before migrations. Every statement is idempotent, so
+// databases created by the old create-at-startup code are brought up to date
+// by it rather than failing.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		// The tables as the models described them when migrations were
+		// introduced, in the order of their foreign keys. Later changes to the
+		// models need migrations of their own.
+		err := exec(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS tenants (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	name varchar NOT NULL,
+	slug varchar NOT NULL UNIQUE,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+)`,
+			`CREATE TABLE IF NOT EXISTS categories (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	name varchar NOT NULL,
+	parent_id uuid REFERENCES categories (id),
+	version bigint NOT NULL DEFAULT 1,
+	deleted_at timestamptz,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS suppliers (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	name varchar NOT NULL,
+	email varchar,
+	phone varchar,
+	version bigint NOT NULL DEFAULT 1,
+	deleted_at timestamptz,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS products (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	name varchar NOT NULL,
+	category_id uuid NOT NULL REFERENCES categories (id),
+	price double precision NOT NULL,
+	quantity double precision NOT NULL,
+	base_unit varchar NOT NULL DEFAULT 'each',
+	fractional boolean NOT NULL DEFAULT false,
+	image_url varchar,
+	supplier_id uuid NOT NULL REFERENCES suppliers (id),
+	attributes jsonb,
+	version bigint NOT NULL DEFAULT 1,
+	deleted_at timestamptz,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS kit_components (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	kit_id uuid NOT NULL REFERENCES products (id),
+	component_id uuid NOT NULL REFERENCES products (id),
+	quantity double precision NOT NULL,
+	tenant_id uuid NOT NULL,
+	CONSTRAINT kit_component UNIQUE (kit_id, component_id)
+)`,
+			`CREATE TABLE IF NOT EXISTS category_attributes (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	category_id uuid NOT NULL REFERENCES categories (id),
+	name varchar NOT NULL,
+	type varchar NOT NULL,
+	unit varchar,
+	required boolean NOT NULL DEFAULT false,
+	allowed_values varchar[],
+	tenant_id uuid NOT NULL,
+	CONSTRAINT category_attribute UNIQUE (category_id, name)
+)`,
+			`CREATE TABLE IF NOT EXISTS product_units (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	product_id uuid NOT NULL REFERENCES products (id),
+	name varchar NOT NULL,
+	factor double precision NOT NULL,
+	tenant_id uuid NOT NULL,
+	CONSTRAINT product_unit UNIQUE (product_id, name)
+)`,
+			`CREATE TABLE IF NOT EXISTS product_images (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	product_id uuid NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+	position bigint NOT NULL,
+	content_type varchar NOT NULL,
+	size bigint NOT NULL,
+	key varchar NOT NULL,
+	thumbnail_keys jsonb,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS users (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	tenant_id uuid NOT NULL REFERENCES tenants (id),
+	username varchar NOT NULL UNIQUE,
+	password_hash varchar NOT NULL,
+	role varchar NOT NULL,
+	active boolean NOT NULL DEFAULT true,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+)`,
+			`CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id uuid NOT NULL PRIMARY KEY,
+	user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	expires_at timestamptz NOT NULL,
+	revoked_at timestamptz
+)`,
+			`CREATE TABLE IF NOT EXISTS api_keys (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	tenant_id uuid NOT NULL REFERENCES tenants (id),
+	integration varchar NOT NULL,
+	prefix varchar NOT NULL UNIQUE,
+	hash varchar NOT NULL,
+	scopes varchar[] NOT NULL,
+	expires_at timestamptz,
+	last_used_at timestamptz,
+	revoked_at timestamptz,
+	rotated_to_id uuid,
+	created_by uuid NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp
+)`,
+			`CREATE TABLE IF NOT EXISTS audit_log (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	actor_kind varchar NOT NULL,
+	actor_id uuid NOT NULL,
+	action varchar NOT NULL,
+	entity_type varchar NOT NULL,
+	entity_id varchar NOT NULL,
+	changes jsonb,
+	request_id varchar,
+	client_ip varchar,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS idempotency_keys (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	actor_id uuid NOT NULL,
+	key varchar NOT NULL,
+	fingerprint varchar NOT NULL,
+	status bigint NOT NULL DEFAULT 0,
+	headers jsonb,
+	body bytea,
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	expires_at timestamptz NOT NULL,
+	tenant_id uuid NOT NULL,
+	CONSTRAINT idempotency_key UNIQUE (actor_id, key)
+)`,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Rows from before multi-tenancy belong to the default tenant
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO tenants (id, name, slug) VALUES (?, 'Default', 'default') ON CONFLICT (id) DO NOTHING`,
+			tenancy.DefaultTenantID)
+		if err != nil {
+			return err
+		}
+
+		// Columns added before migrations, which CREATE TABLE IF NOT EXISTS
+		// did not add to existing tables
+		err = exec(ctx, tx,
+			`ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id uuid REFERENCES categories(id)`,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS attributes jsonb`,
+			`ALTER TABLE products ALTER COLUMN quantity TYPE double precision`,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS base_unit varchar NOT NULL DEFAULT 'each'`,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS fractional boolean NOT NULL DEFAULT false`,
+			`ALTER TABLE kit_components ALTER COLUMN quantity TYPE double precision`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES tenants(id)`,
+			`ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT`,
+			`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES tenants(id)`,
+			`ALTER TABLE api_keys ALTER COLUMN tenant_id DROP DEFAULT`,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at timestamptz`,
+			`ALTER TABLE categories ADD COLUMN IF NOT EXISTS deleted_at timestamptz`,
+			`ALTER TABLE suppliers ADD COLUMN IF NOT EXISTS deleted_at timestamptz`,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1`,
+			`ALTER TABLE categories ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1`,
+			`ALTER TABLE suppliers ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1`,
+			`CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity_type, entity_id, created_at)`,
+			`CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log (created_at)`,
+			`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at)`,
+			bumpVersionFunction,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range []string{"products", "categories", "suppliers"} {
+			if err := exec(ctx, tx, rowVersioning(table)); err != nil {
+				return fmt.Errorf("enable versioning on %s: %w", table, err)
+			}
+		}
+
+		for _, table := range []string{
+			"categories", "suppliers", "products", "kit_components", "category_attributes",
+			"product_units", "product_images", "audit_log", "idempotency_keys",
+		} {
+			if err := exec(ctx, tx, tenantIsolation(table)); err != nil {
+				return fmt.Errorf("enable tenant isolation on %s: %w", table, err)
+			}
+		}
+		return nil
+	}), irreversible)
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Orders and their items, which the order handlers used to create on first use.
+// Databases where they already exist may hold orders from before this
+// migration, so it is not rolled back.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		err := exec(ctx, tx, `DO $$
+BEGIN
+	CREATE TYPE order_status AS ENUM ('pending', 'completed', 'cancelled');
+EXCEPTION
+	WHEN duplicate_object THEN NULL;
+END
+$$`)
+		if err != nil {
+			return err
+		}
+
+		// The tables as the handlers created them. The IDs on items are bytea,
+		// which bun chose for their UUID fields without a uuid type.
+		return exec(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS orders (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	order_date timestamptz,
+	status order_status,
+	total_amount double precision,
+	tenant_id uuid NOT NULL
+)`,
+			`CREATE TABLE IF NOT EXISTS order_items (
+	id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+	order_id bytea NOT NULL,
+	product_id bytea NOT NULL,
+	quantity double precision NOT NULL,
+	unit varchar,
+	base_quantity double precision NOT NULL,
+	price double precision NOT NULL,
+	tenant_id uuid NOT NULL
+)`,
+			// Items created before units of measure were counted in base units
+			`ALTER TABLE order_items ALTER COLUMN quantity TYPE double precision`,
+			`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS unit varchar`,
+			`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS base_quantity double precision`,
+			`UPDATE order_items SET base_quantity = quantity WHERE base_quantity IS NULL`,
+			`ALTER TABLE order_items ALTER COLUMN base_quantity SET NOT NULL`,
+			tenantIsolation("orders"),
+			tenantIsolation("order_items"),
+		)
+	}), irreversible)
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Unique indexes make the database refuse duplicates, which checking for them
+// before an insert cannot do when two requests race. Names and emails are
+// compared case-insensitively within a tenant, and deleted rows free theirs.
+// The migration fails while a tenant has duplicates; they have to be renamed
+// or deleted first.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`CREATE UNIQUE INDEX IF NOT EXISTS categories_name_key ON categories (tenant_id, LOWER(name)) WHERE deleted_at IS NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS suppliers_name_key ON suppliers (tenant_id, LOWER(name)) WHERE deleted_at IS NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS suppliers_email_key ON suppliers (tenant_id, LOWER(email)) WHERE deleted_at IS NULL AND email <> ''`,
+		)
+	}), inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`DROP INDEX IF EXISTS categories_name_key`,
+			`DROP INDEX IF EXISTS suppliers_name_key`,
+			`DROP INDEX IF EXISTS suppliers_email_key`,
+		)
+	}))
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Products get a reorder point, the stock at or below which they should be
+// ordered again. Zero means the product has none.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_point double precision NOT NULL DEFAULT 0`,
+		)
+	}), inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`ALTER TABLE products DROP COLUMN IF EXISTS reorder_point`,
+		)
+	}))
+}
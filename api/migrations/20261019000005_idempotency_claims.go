@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Idempotency keys record how long their first request holds them, so a key
+// whose request never finished can be used again. Requests in progress when
+// this runs are taken to have lapsed.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claimed_until timestamptz NOT NULL DEFAULT current_timestamp`,
+		)
+	}), inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS claimed_until`,
+		)
+	}))
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Purchase orders, stock ordered from a supplier, and their items. Receiving
+// an order adds its items to stock in the products' base units.
+func init() {
+	Migrations.MustRegister(inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`CREATE TABLE IF NOT EXISTS purchase_orders (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	supplier_id uuid NOT NULL REFERENCES suppliers(id),
+	status varchar NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'received', 'cancelled')),
+	created_at timestamptz NOT NULL DEFAULT current_timestamp,
+	received_at timestamptz
+)`,
+			`CREATE TABLE IF NOT EXISTS purchase_order_items (
+	id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	purchase_order_id uuid NOT NULL REFERENCES purchase_orders(id) ON DELETE CASCADE,
+	product_id uuid NOT NULL REFERENCES products(id),
+	quantity double precision NOT NULL,
+	unit varchar,
+	base_quantity double precision NOT NULL,
+	unit_cost double precision NOT NULL DEFAULT 0
+)`,
+			`CREATE INDEX IF NOT EXISTS purchase_order_items_order ON purchase_order_items (purchase_order_id)`,
+			tenantIsolation("purchase_orders"),
+			tenantIsolation("purchase_order_items"),
+		)
+	}), inTx(func(ctx context.Context, tx bun.Tx) error {
+		return exec(ctx, tx,
+			`DROP TABLE IF EXISTS purchase_order_items`,
+			`DROP TABLE IF EXISTS purchase_orders`,
+		)
+	}))
+}
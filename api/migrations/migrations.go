@@ -0,0 +1,70 @@
+// Package migrations holds the versioned changes to the database schema.
+//
+// Each change is a file named after the time it was written and what it does,
+// such as 20261019000003_unique_names.go, that registers an up and a down
+// function with Migrations in its init. Migrations run in order, and the ones
+// that have run are recorded in the schema_migrations table, so every database
+// gets each change exactly once. A change that has been released is never
+// edited; a new migration corrects it.
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Migrations are all migrations of the API, in the order of their names
+var Migrations = migrate.NewMigrations()
+
+// errIrreversible is returned by the down function of migrations that cannot
+// be undone without losing data
+var errIrreversible = errors.New("migration cannot be rolled back")
+
+// NewMigrator returns a migrator for db. A migration is only recorded as
+// applied, or rolled back, once it has succeeded.
+func NewMigrator(db *bun.DB) *migrate.Migrator {
+	return migrate.NewMigrator(db, Migrations,
+		migrate.WithTableName("schema_migrations"),
+		migrate.WithLocksTableName("schema_migration_locks"),
+		migrate.WithMarkAppliedOnSuccess(true),
+	)
+}
+
+// Pending returns the migrations that have not been applied to db yet
+func Pending(ctx context.Context, db *bun.DB) (migrate.MigrationSlice, error) {
+	migrator := NewMigrator(db)
+	if err := migrator.Init(ctx); err != nil {
+		return nil, err
+	}
+	migrations, err := migrator.MigrationsWithStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return migrations.Unapplied(), nil
+}
+
+// inTx runs the statements of a migration in one transaction, so a migration
+// that fails leaves nothing behind
+func inTx(fn func(ctx context.Context, tx bun.Tx) error) migrate.MigrationFunc {
+	return func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, fn)
+	}
+}
+
+// exec runs statements in order
+func exec(ctx context.Context, tx bun.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// irreversible is the down function of migrations that cannot be undone
+func irreversible(ctx context.Context, db *bun.DB) error {
+	return errIrreversible
+}
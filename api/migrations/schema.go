@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"fmt"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+)
+
+// bumpVersionFunction increments version when an update changes a row. The
+// version written by the update itself is ignored, so clients cannot set it.
+const bumpVersionFunction = `CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+	NEW.version := OLD.version;
+	IF NEW IS DISTINCT FROM OLD THEN
+		NEW.version := OLD.version + 1;
+	END IF;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql`
+
+// rowVersioning installs the bump_version trigger on a table, whose version
+// column the API hands out as the row's ETag
+func rowVersioning(table string) string {
+	return fmt.Sprintf(`DO $$
+BEGIN
+	DROP TRIGGER IF EXISTS %[1]s_version ON %[1]s;
+	CREATE TRIGGER %[1]s_version BEFORE UPDATE ON %[1]s
+		FOR EACH ROW EXECUTE FUNCTION bump_version();
+END
+$$`, table)
+}
+
+// tenantIsolation adds tenant_id to a table, assigns existing rows to the
+// default tenant and enables row-level security, so that even a query that
+// forgets the tenant filter only sees the tenant set in app.tenant_id. FORCE
+// makes the policy apply to the table owner too; it does not apply to
+// superusers, so the API must not connect as one.
+func tenantIsolation(table string) string {
+	current := fmt.Sprintf("NULLIF(current_setting('%s', true), '')::uuid", tenancy.SettingName)
+	return fmt.Sprintf(`DO $$
+BEGIN
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS tenant_id uuid;
+	UPDATE %[1]s SET tenant_id = '%[2]s' WHERE tenant_id IS NULL;
+	ALTER TABLE %[1]s ALTER COLUMN tenant_id SET NOT NULL;
+	ALTER TABLE %[1]s ALTER COLUMN tenant_id SET DEFAULT %[3]s;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = '%[1]s_tenant_id_fkey') THEN
+		ALTER TABLE %[1]s ADD CONSTRAINT %[1]s_tenant_id_fkey FOREIGN KEY (tenant_id) REFERENCES tenants(id);
+	END IF;
+	ALTER TABLE %[1]s ENABLE ROW LEVEL SECURITY;
+	ALTER TABLE %[1]s FORCE ROW LEVEL SECURITY;
+	DROP POLICY IF EXISTS tenant_isolation ON %[1]s;
+	CREATE POLICY tenant_isolation ON %[1]s
+		USING (tenant_id = %[3]s)
+		WITH CHECK (tenant_id = %[3]s);
+END
+$$`, table, tenancy.DefaultTenantID, current)
+}
@@ -0,0 +1,179 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// testDatabaseEnv names the Postgres database the tests migrate and write to.
+// It must not be a superuser's connection, since superusers bypass row-level
+// security.
+const testDatabaseEnv = "TEST_DATABASE_URL"
+
+// migratedDB connects to the test database and applies every migration
+func migratedDB(t *testing.T) *bun.DB {
+	t.Helper()
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	db, err := database.ConnectDb(dsn, database.PoolConfig{MaxOpenConns: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	migrator := NewMigrator(db)
+	if err := migrator.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Unlock(ctx)
+	if _, err := migrator.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// scopedConn returns a connection limited to a tenant, as the API's
+// TenantScope middleware sets one up
+func scopedConn(t *testing.T, db *bun.DB, tenantID uuid.UUID) bun.Conn {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.ExecContext(ctx, "SELECT set_config(?, ?, false)", tenancy.SettingName, tenantID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// createTenant adds a tenant with one category and returns both IDs
+func createTenant(t *testing.T, db *bun.DB) (tenantID, categoryID uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	tenantID = uuid.New()
+	_, err := db.ExecContext(ctx, "INSERT INTO tenants (id, name, slug) VALUES (?, ?, ?)",
+		tenantID, "Test "+tenantID.String(), "test-"+tenantID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := scopedConn(t, db, tenantID)
+	err = conn.QueryRowContext(ctx, "INSERT INTO categories (name) VALUES ('Tools') RETURNING id").Scan(&categoryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", categoryID)
+		db.ExecContext(ctx, "DELETE FROM tenants WHERE id = ?", tenantID)
+	})
+	return tenantID, categoryID
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := migratedDB(t)
+	ctx := context.Background()
+
+	var superuser bool
+	if err := db.QueryRowContext(ctx, "SELECT usesuper FROM pg_user WHERE usename = current_user").Scan(&superuser); err != nil {
+		t.Fatal(err)
+	}
+	if superuser {
+		t.Skipf("%s connects as a superuser, which bypasses row-level security", testDatabaseEnv)
+	}
+	var forced bool
+	if err := db.QueryRowContext(ctx, "SELECT relforcerowsecurity FROM pg_class WHERE relname = 'categories'").Scan(&forced); err != nil {
+		t.Fatal(err)
+	}
+	if !forced {
+		t.Fatal("row-level security is not forced on categories")
+	}
+
+	tenantA, ownID := createTenant(t, db)
+	tenantB, otherID := createTenant(t, db)
+	conn := scopedConn(t, db, tenantA)
+
+	// The statements name rows by ID alone, as a query missing its tenant
+	// filter would, so only the policy keeps tenant B's row out of reach
+	t.Run("read", func(t *testing.T) {
+		var count int
+		err := conn.QueryRowContext(ctx, "SELECT count(*) FROM categories WHERE id IN (?, ?)", ownID, otherID).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("saw %d categories, want only the tenant's own", count)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		result, err := conn.ExecContext(ctx, "UPDATE categories SET name = 'Taken' WHERE id = ?", otherID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := result.RowsAffected(); n != 0 {
+			t.Errorf("updated %d of another tenant's categories", n)
+		}
+	})
+
+	t.Run("move to another tenant", func(t *testing.T) {
+		_, err := conn.ExecContext(ctx, "UPDATE categories SET tenant_id = ? WHERE id = ?", tenantB, ownID)
+		if err == nil {
+			t.Error("moved a category to another tenant")
+		}
+	})
+
+	t.Run("insert for another tenant", func(t *testing.T) {
+		_, err := conn.ExecContext(ctx, "INSERT INTO categories (name, tenant_id) VALUES ('Planted', ?)", tenantB)
+		if err == nil {
+			t.Error("inserted a category for another tenant")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		result, err := conn.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", otherID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := result.RowsAffected(); n != 0 {
+			t.Errorf("deleted %d of another tenant's categories", n)
+		}
+	})
+
+	t.Run("other tenant unaffected", func(t *testing.T) {
+		var name string
+		err := scopedConn(t, db, tenantB).QueryRowContext(ctx, "SELECT name FROM categories WHERE id = ?", otherID).Scan(&name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "Tools" {
+			t.Errorf("other tenant's category is named %q, want Tools", name)
+		}
+	})
+
+	t.Run("no tenant", func(t *testing.T) {
+		var count int
+		err := db.QueryRowContext(ctx, "SELECT count(*) FROM categories WHERE id IN (?, ?)", ownID, otherID).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("saw %d categories without a tenant, want none", count)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// APIKey lets a machine client such as a POS terminal call the API without a
+// user login. Only a hash of the key is stored; Prefix identifies it.
+type APIKey struct {
+	bun.BaseModel `bun:"table:api_keys,alias:ak"`
+
+	ID          uuid.UUID  `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	TenantID    uuid.UUID  `bun:"tenant_id,type:uuid,notnull"`
+	Tenant      *Tenant    `bun:"rel:belongs-to,join:tenant_id=id" json:"-"`
+	Integration string     `bun:"integration,notnull"`
+	Prefix      string     `bun:"prefix,notnull,unique"`
+	Hash        string     `bun:"hash,notnull" json:"-"`
+	Scopes      []string   `bun:"scopes,array,notnull"`
+	ExpiresAt   *time.Time `bun:"expires_at"`
+	LastUsedAt  *time.Time `bun:"last_used_at"`
+	RevokedAt   *time.Time `bun:"revoked_at"`
+	RotatedToID *uuid.UUID `bun:"rotated_to_id,type:uuid"`
+	CreatedBy   uuid.UUID  `bun:"created_by,type:uuid,notnull"`
+	CreatedAt   time.Time  `bun:"created_at,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Types an attribute value can have
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+)
+
+// CategoryAttribute defines a custom field that products in a category, or in
+// any of its subcategories, may carry in Products.Attributes
+type CategoryAttribute struct {
+	bun.BaseModel `bun:"table:category_attributes,alias:ca"`
+	TenantOwned
+
+	ID            uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	CategoryID    uuid.UUID `bun:"category_id,type:uuid,notnull,unique:category_attribute"`
+	Category      *Category `bun:"rel:belongs-to,join:category_id=id" json:"-"`
+	Name          string    `bun:"name,notnull,unique:category_attribute"`
+	Type          string    `bun:"type,notnull"`
+	Unit          string    `bun:"unit"`
+	Required      bool      `bun:"required,notnull,default:false"`
+	AllowedValues []string  `bun:"allowed_values,array"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// AuditEntry records one create, update or delete made through the API. It is
+// written in the same transaction as the change it describes.
+type AuditEntry struct {
+	bun.BaseModel `bun:"table:audit_log,alias:al"`
+	TenantOwned
+
+	ID         uuid.UUID              `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	CreatedAt  time.Time              `bun:"created_at,notnull,default:current_timestamp"`
+	ActorKind  string                 `bun:"actor_kind,notnull"`
+	ActorID    uuid.UUID              `bun:"actor_id,type:uuid,notnull"`
+	Action     string                 `bun:"action,notnull"`
+	EntityType string                 `bun:"entity_type,notnull"`
+	EntityID   string                 `bun:"entity_id,notnull"`
+	Changes    map[string]AuditChange `bun:"changes,type:jsonb"`
+	RequestID  string                 `bun:"request_id"`
+	ClientIP   string                 `bun:"client_ip"`
+}
+
+// AuditChange is the value of one field before and after a change. Before is
+// null for creates and After is null for deletes.
+type AuditChange struct {
+	Before interface{}
+	After  interface{}
+}
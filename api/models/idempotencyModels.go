@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// IdempotencyKey remembers a POST sent with an Idempotency-Key header so a
+// retry gets the first response instead of repeating the request. Keys are
+// scoped to the principal that sent them. Status is 0 while the first request
+// is still running, which it may claim the key for until ClaimedUntil.
+type IdempotencyKey struct {
+	bun.BaseModel `bun:"table:idempotency_keys,alias:ik"`
+	TenantOwned
+
+	ID           uuid.UUID         `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	ActorID      uuid.UUID         `bun:"actor_id,type:uuid,notnull,unique:idempotency_key"`
+	Key          string            `bun:"key,notnull,unique:idempotency_key"`
+	Fingerprint  string            `bun:"fingerprint,notnull"`
+	Status       int               `bun:"status,notnull,default:0"`
+	Headers      map[string]string `bun:"headers,type:jsonb"`
+	Body         []byte            `bun:"body"`
+	CreatedAt    time.Time         `bun:"created_at,notnull,default:current_timestamp"`
+	ClaimedUntil time.Time         `bun:"claimed_until,notnull,default:current_timestamp"`
+	ExpiresAt    time.Time         `bun:"expires_at,notnull"`
+}
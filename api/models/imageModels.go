@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// ProductImage is an uploaded image of a product. Keys locate the original and
+// its thumbnails in storage; the URL fields are filled in for responses.
+type ProductImage struct {
+	bun.BaseModel `bun:"table:product_images,alias:pi"`
+	TenantOwned
+
+	ID            uuid.UUID         `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	ProductID     uuid.UUID         `bun:"product_id,type:uuid,notnull"`
+	Product       *Products         `bun:"rel:belongs-to,join:product_id=id,on_delete:CASCADE" json:"-"`
+	Position      int               `bun:"position,notnull"`
+	ContentType   string            `bun:"content_type,notnull"`
+	Size          int64             `bun:"size,notnull"`
+	Key           string            `bun:"key,notnull" json:"-"`
+	ThumbnailKeys map[string]string `bun:"thumbnail_keys,type:jsonb" json:"-"`
+	CreatedAt     time.Time         `bun:"created_at,notnull,default:current_timestamp"`
+
+	URL           string            `bun:"-"`
+	ThumbnailURLs map[string]string `bun:"-"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// KitComponent is one line of a kit's bill of materials: building a single
+// unit of the kit product consumes Quantity units of the component product.
+type KitComponent struct {
+	bun.BaseModel `bun:"table:kit_components,alias:kc"`
+	TenantOwned
+
+	ID          uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	KitID       uuid.UUID `bun:"kit_id,type:uuid,notnull,unique:kit_component"`
+	Kit         *Products `bun:"rel:belongs-to,join:kit_id=id" json:"-"`
+	ComponentID uuid.UUID `bun:"component_id,type:uuid,notnull,unique:kit_component"`
+	Component   *Products `bun:"rel:belongs-to,join:component_id=id"`
+	Quantity    float64   `bun:"quantity,notnull"`
+}
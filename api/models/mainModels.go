@@ -10,33 +10,54 @@ import (
 )
 
 type Products struct {
-    bun.BaseModel `bun:"table:products"`
-
-    ID         uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
-    Name       string    `bun:"name,notnull"`
-    CategoryID uuid.UUID `bun:"category_id,type:uuid,notnull"`
-    Category   Category  `bun:"rel:belongs-to,join:category_id=id"`
-    Price      float64   `bun:"price,notnull"`
-    Quantity   int       `bun:"quantity,notnull"`
-    ImageURL   string    `bun:"image_url"`
-    SupplierID uuid.UUID `bun:"supplier_id,type:uuid,notnull"`
-    Supplier   Supplier  `bun:"rel:belongs-to,join:supplier_id=id"`
+	bun.BaseModel `bun:"table:products"`
+	TenantOwned
+
+	ID           uuid.UUID              `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	Name         string                 `bun:"name,notnull" validate:"required,max=255"`
+	CategoryID   uuid.UUID              `bun:"category_id,type:uuid,notnull" validate:"required"`
+	Category     Category               `bun:"rel:belongs-to,join:category_id=id"`
+	Price        float64                `bun:"price,notnull" validate:"min=0"`
+	Quantity     float64                `bun:"quantity,notnull" validate:"min=0"`
+	ReorderPoint float64                `bun:"reorder_point,notnull,default:0" validate:"min=0"`
+	BaseUnit     string                 `bun:"base_unit,notnull,default:'each'" validate:"max=50"`
+	Fractional   bool                   `bun:"fractional,notnull,default:false"`
+	ImageURL     string                 `bun:"image_url"`
+	SupplierID   uuid.UUID              `bun:"supplier_id,type:uuid,notnull" validate:"required"`
+	Supplier     Supplier               `bun:"rel:belongs-to,join:supplier_id=id"`
+	Attributes   map[string]interface{} `bun:"attributes,type:jsonb,nullzero"`
+	Version      int64                  `bun:"version,notnull,nullzero,default:1"`
+	DeletedAt    time.Time              `bun:"deleted_at,soft_delete,nullzero"`
 }
 
 type Category struct {
-    bun.BaseModel `bun:"table:categories"`
+	bun.BaseModel `bun:"table:categories"`
+	TenantOwned
+
+	ID        uuid.UUID  `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	Name      string     `bun:"name,notnull" validate:"required,max=255"`
+	ParentID  *uuid.UUID `bun:"parent_id,type:uuid"`
+	Parent    *Category  `bun:"rel:belongs-to,join:parent_id=id" json:"-"`
+	Version   int64      `bun:"version,notnull,nullzero,default:1"`
+	DeletedAt time.Time  `bun:"deleted_at,soft_delete,nullzero"`
+}
 
-    ID   uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
-    Name string    `bun:"name,notnull"`
+// CategoryNode is a category with its descendants, as returned by the tree endpoint
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode
 }
 
 type Supplier struct {
-    bun.BaseModel `bun:"table:suppliers"`
+	bun.BaseModel `bun:"table:suppliers"`
+	TenantOwned
 
-    ID    uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
-    Name  string    `bun:"name,notnull"`
-    Email string    `bun:"email"`
-    Phone string    `bun:"phone"`
+	ID        uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	Name      string    `bun:"name,notnull" validate:"required,max=255"`
+	Email     string    `bun:"email" validate:"email"`
+	Phone     string    `bun:"phone" validate:"max=50"`
+	Version   int64     `bun:"version,notnull,nullzero,default:1"`
+	DeletedAt time.Time `bun:"deleted_at,soft_delete,nullzero"`
 }
 
 type Status string
@@ -50,21 +71,25 @@ func (s Status) Value() (driver.Value, error) {
 	return string(s), nil
 }
 
-type Orders  struct{
+type Orders struct {
 	bun.BaseModel `bun:"table:orders"`
+	TenantOwned
 
-	Id uuid.UUID `bun:",pk,type:uuid,default:gen_random_uuid()"` 
+	Id          uuid.UUID `bun:",pk,type:uuid,default:gen_random_uuid()"`
 	OrderDate   time.Time `bun:"order_date"`
 	Status      Status    `bun:"status,type:order_status"`
-	TotalAmount float64   `bun:"total_amount"`                           
+	TotalAmount float64   `bun:"total_amount" validate:"min=0"`
 }
 
 type OrderItem struct {
 	bun.BaseModel `bun:"table:order_items,alias:oi"`
+	TenantOwned
 
-	ID        uuid.UUID `bun:",pk,type:uuid,default:gen_random_uuid()"` // Primary key
-	OrderID   uuid.UUID `bun:"order_id,notnull"`                       // Foreign key to Orders
-	ProductID uuid.UUID `bun:"product_id,notnull"`                     // Foreign key to Products
-	Quantity  int       `bun:"quantity,notnull"`                       // Quantity
-	Price     float64   `bun:"price,notnull"`                          // Price
-}
\ No newline at end of file
+	ID           uuid.UUID `bun:",pk,type:uuid,default:gen_random_uuid()"` // Primary key
+	OrderID      uuid.UUID `bun:"order_id,notnull"`                        // Foreign key to Orders
+	ProductID    uuid.UUID `bun:"product_id,notnull"`                      // Foreign key to Products
+	Quantity     float64   `bun:"quantity,notnull"`                        // Quantity in Unit
+	Unit         string    `bun:"unit"`                                    // Unit ordered in, empty for the base unit
+	BaseQuantity float64   `bun:"base_quantity,notnull"`                   // Quantity in the product's base unit
+	Price        float64   `bun:"price,notnull"`                           // Price
+}
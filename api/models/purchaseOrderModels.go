@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Statuses of a purchase order
+const (
+	PurchaseOrderOpen      = "open"
+	PurchaseOrderReceived  = "received"
+	PurchaseOrderCancelled = "cancelled"
+)
+
+// PurchaseOrder is stock ordered from a supplier. Its items are added to
+// stock when it is received, which it can only be once.
+type PurchaseOrder struct {
+	bun.BaseModel `bun:"table:purchase_orders,alias:po"`
+	TenantOwned
+
+	ID         uuid.UUID           `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	SupplierID uuid.UUID           `bun:"supplier_id,type:uuid,notnull"`
+	Status     string              `bun:"status,notnull,default:'open'"`
+	CreatedAt  time.Time           `bun:"created_at,notnull,default:current_timestamp"`
+	ReceivedAt *time.Time          `bun:"received_at"`
+	Items      []PurchaseOrderItem `bun:"rel:has-many,join:id=purchase_order_id"`
+}
+
+// PurchaseOrderItem is a product on a purchase order, in any unit defined for
+// it. BaseQuantity is the quantity in the product's base unit, which is what
+// receiving the order adds to stock.
+type PurchaseOrderItem struct {
+	bun.BaseModel `bun:"table:purchase_order_items,alias:poi"`
+	TenantOwned
+
+	ID              uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	PurchaseOrderID uuid.UUID `bun:"purchase_order_id,type:uuid,notnull"`
+	ProductID       uuid.UUID `bun:"product_id,type:uuid,notnull"`
+	Quantity        float64   `bun:"quantity,notnull"`
+	Unit            string    `bun:"unit"`
+	BaseQuantity    float64   `bun:"base_quantity,notnull"`
+	UnitCost        float64   `bun:"unit_cost,notnull"`
+}
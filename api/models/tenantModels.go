@@ -0,0 +1,74 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Tenant is a company sharing the deployment. Every inventory row belongs to one.
+type Tenant struct {
+	bun.BaseModel `bun:"table:tenants"`
+
+	ID        uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	Name      string    `bun:"name,notnull"`
+	Slug      string    `bun:"slug,notnull,unique"`
+	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// TenantOwned is embedded in every model whose rows belong to a tenant. Its
+// hooks limit selects, updates and deletes to the tenant in the query context
+// and stamp that tenant on inserted rows.
+type TenantOwned struct {
+	TenantID uuid.UUID `bun:"tenant_id,type:uuid,notnull,nullzero" json:"-"`
+}
+
+var (
+	_ bun.BeforeSelectHook      = (*TenantOwned)(nil)
+	_ bun.BeforeUpdateHook      = (*TenantOwned)(nil)
+	_ bun.BeforeDeleteHook      = (*TenantOwned)(nil)
+	_ bun.BeforeAppendModelHook = (*TenantOwned)(nil)
+)
+
+func (*TenantOwned) BeforeSelect(ctx context.Context, query *bun.SelectQuery) error {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	query.Where("?TableAlias.tenant_id = ?", tenantID)
+	return nil
+}
+
+func (*TenantOwned) BeforeUpdate(ctx context.Context, query *bun.UpdateQuery) error {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	query.Where("?TableAlias.tenant_id = ?", tenantID)
+	return nil
+}
+
+func (*TenantOwned) BeforeDelete(ctx context.Context, query *bun.DeleteQuery) error {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	query.Where("?TableAlias.tenant_id = ?", tenantID)
+	return nil
+}
+
+// BeforeAppendModel runs for each row written by an insert or update
+func (t *TenantOwned) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery, *bun.UpdateQuery:
+		tenantID, ok := tenancy.FromContext(ctx)
+		if !ok {
+			return tenancy.ErrNoTenant
+		}
+		t.TenantID = tenantID
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// testDB builds queries without connecting to a database
+func testDB() *bun.DB {
+	return bun.NewDB(sql.OpenDB(pgdriver.NewConnector()), pgdialect.New())
+}
+
+func TestTenantOwnedHooks(t *testing.T) {
+	db := testDB()
+	tenantID := uuid.New()
+	tenantCtx := tenancy.WithTenant(context.Background(), tenantID)
+	filter := `"category".tenant_id = '` + tenantID.String() + `'`
+
+	hooks := []struct {
+		name string
+		run  func(ctx context.Context) (string, error)
+	}{
+		{"select", func(ctx context.Context) (string, error) {
+			query := db.NewSelect().Model((*Category)(nil))
+			err := (*TenantOwned)(nil).BeforeSelect(ctx, query)
+			return query.String(), err
+		}},
+		{"update", func(ctx context.Context) (string, error) {
+			query := db.NewUpdate().Model((*Category)(nil)).Set("name = ?", "x").Where("id = ?", uuid.New())
+			err := (*TenantOwned)(nil).BeforeUpdate(ctx, query)
+			return query.String(), err
+		}},
+		{"delete", func(ctx context.Context) (string, error) {
+			query := db.NewDelete().Model((*Category)(nil)).Where("id = ?", uuid.New()).ForceDelete()
+			err := (*TenantOwned)(nil).BeforeDelete(ctx, query)
+			return query.String(), err
+		}},
+	}
+	for _, hook := range hooks {
+		t.Run(hook.name, func(t *testing.T) {
+			query, err := hook.run(tenantCtx)
+			if err != nil {
+				t.Fatalf("hook failed: %v", err)
+			}
+			if !strings.Contains(query, filter) {
+				t.Errorf("query %s is not limited to the tenant", query)
+			}
+
+			if _, err := hook.run(context.Background()); !errors.Is(err, tenancy.ErrNoTenant) {
+				t.Errorf("without a tenant got %v, want %v", err, tenancy.ErrNoTenant)
+			}
+		})
+	}
+}
+
+func TestTenantOwnedStampsTenant(t *testing.T) {
+	db := testDB()
+	tenantID := uuid.New()
+	ctx := tenancy.WithTenant(context.Background(), tenantID)
+
+	category := Category{TenantOwned: TenantOwned{TenantID: uuid.New()}, Name: "Tools"}
+	if err := category.BeforeAppendModel(ctx, db.NewInsert().Model(&category)); err != nil {
+		t.Fatalf("hook failed: %v", err)
+	}
+	if category.TenantID != tenantID {
+		t.Errorf("inserted row belongs to %s, want %s", category.TenantID, tenantID)
+	}
+
+	err := category.BeforeAppendModel(context.Background(), db.NewInsert().Model(&category))
+	if !errors.Is(err, tenancy.ErrNoTenant) {
+		t.Errorf("without a tenant got %v, want %v", err, tenancy.ErrNoTenant)
+	}
+}
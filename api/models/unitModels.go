@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// DefaultBaseUnit is the unit stock is kept in when a product does not name one
+const DefaultBaseUnit = "each"
+
+// ProductUnit is an alternative unit a product can be bought, received or sold
+// in, such as a case of 24. Factor is the number of base units in one of it.
+type ProductUnit struct {
+	bun.BaseModel `bun:"table:product_units,alias:pu"`
+	TenantOwned
+
+	ID        uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	ProductID uuid.UUID `bun:"product_id,type:uuid,notnull,unique:product_unit"`
+	Product   *Products `bun:"rel:belongs-to,join:product_id=id" json:"-"`
+	Name      string    `bun:"name,notnull,unique:product_unit"`
+	Factor    float64   `bun:"factor,notnull"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// User is an account that can log in to the API
+type User struct {
+	bun.BaseModel `bun:"table:users"`
+
+	ID           uuid.UUID `bun:"id,pk,type:uuid,default:gen_random_uuid()"`
+	TenantID     uuid.UUID `bun:"tenant_id,type:uuid,notnull"`
+	Tenant       *Tenant   `bun:"rel:belongs-to,join:tenant_id=id" json:"-"`
+	Username     string    `bun:"username,notnull,unique"`
+	PasswordHash string    `bun:"password_hash,notnull" json:"-"`
+	Role         string    `bun:"role,notnull"`
+	Active       bool      `bun:"active,notnull,default:true"`
+	CreatedAt    time.Time `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+// RefreshToken records an issued refresh token so it can be rotated and revoked.
+// ID is the token's jti claim.
+type RefreshToken struct {
+	bun.BaseModel `bun:"table:refresh_tokens,alias:rt"`
+
+	ID        uuid.UUID  `bun:"id,pk,type:uuid"`
+	UserID    uuid.UUID  `bun:"user_id,type:uuid,notnull"`
+	User      *User      `bun:"rel:belongs-to,join:user_id=id,on_delete:CASCADE"`
+	ExpiresAt time.Time  `bun:"expires_at,notnull"`
+	RevokedAt *time.Time `bun:"revoked_at"`
+}
@@ -0,0 +1,252 @@
+// Package problem describes failed requests as RFC 7807 problem details.
+//
+// Handlers return a *Problem as their error instead of writing an error
+// response themselves, and Handler, installed as the app's fiber
+// ErrorHandler, sends it as application/problem+json:
+//
+//	{
+//	  "type": "/problems/product_not_found",
+//	  "title": "Product not found",
+//	  "status": 404,
+//	  "code": "product_not_found",
+//	  "instance": "/products/…",
+//	  "request_id": "…",
+//	  "trace_id": "…"
+//	}
+//
+// Code is stable and meant for clients to branch on; title and detail are for
+// people. Any error that is not a Problem is an internal error: it is logged
+// with the request and trace IDs, and the client only learns that something
+// went wrong.
+// Internal errors caused by the request running out of time are the exception,
+// see From.
+package problem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// TypeBase is prefixed to a problem's code to form its type URI
+var TypeBase = "/problems/"
+
+// Codes shared by problems that are not specific to one resource
+const (
+	CodeValidation  = "validation_failed"
+	CodeInternal    = "internal_error"
+	CodeTimeout     = "request_timeout"
+	CodeUnavailable = "service_unavailable"
+)
+
+// Problem is a failed request. Cause is the underlying error, if any; it is
+// logged for internal errors and never sent to the client.
+type Problem struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+	// Errors lists the failed checks of a validation problem
+	Errors validation.Errors
+	// Extra holds additional members of the response, such as the field a
+	// conflict is about
+	Extra map[string]interface{}
+	Cause error
+}
+
+func (p *Problem) Error() string {
+	message := p.Title
+	if p.Detail != "" {
+		message += ": " + p.Detail
+	}
+	if p.Cause != nil {
+		message += ": " + p.Cause.Error()
+	}
+	return message
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Cause
+}
+
+// WithDetail sets the explanation of this occurrence of the problem
+func (p *Problem) WithDetail(detail string) *Problem {
+	p.Detail = detail
+	return p
+}
+
+// Detailf sets the detail from a format string
+func (p *Problem) Detailf(format string, args ...interface{}) *Problem {
+	return p.WithDetail(fmt.Sprintf(format, args...))
+}
+
+// With adds a member to the response
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extra == nil {
+		p.Extra = map[string]interface{}{}
+	}
+	p.Extra[key] = value
+	return p
+}
+
+// New returns a problem with any status
+func New(status int, code, title string) *Problem {
+	return &Problem{Status: status, Code: code, Title: title}
+}
+
+// BadRequest is a request that cannot be understood, such as a malformed body
+func BadRequest(code, title string) *Problem {
+	return New(fiber.StatusBadRequest, code, title)
+}
+
+// Unauthorized is a request without valid credentials
+func Unauthorized(code, title string) *Problem {
+	return New(fiber.StatusUnauthorized, code, title)
+}
+
+// Forbidden is a request the principal has no permission for
+func Forbidden(code, title string) *Problem {
+	return New(fiber.StatusForbidden, code, title)
+}
+
+// NotFound is a request for something that does not exist
+func NotFound(code, title string) *Problem {
+	return New(fiber.StatusNotFound, code, title)
+}
+
+// Conflict is a request that clashes with the current state of a resource
+func Conflict(code, title string) *Problem {
+	return New(fiber.StatusConflict, code, title)
+}
+
+// Unprocessable is a well-formed request that cannot be carried out
+func Unprocessable(code, title string) *Problem {
+	return New(fiber.StatusUnprocessableEntity, code, title)
+}
+
+// Validation is a request that breaks validation rules
+func Validation(errs validation.Errors) *Problem {
+	p := Unprocessable(CodeValidation, "Validation failed").WithDetail(errs.Error())
+	p.Errors = errs
+	return p
+}
+
+// Internal is a failure of the server. The title says what failed, such as
+// "Failed to fetch products"; cause is logged but not sent.
+func Internal(cause error, title string) *Problem {
+	return &Problem{Status: fiber.StatusInternalServerError, Code: CodeInternal, Title: title, Cause: cause}
+}
+
+// Unavailable is a request the server cannot take on right now, such as when
+// no database connection frees up in time
+func Unavailable(code, title string) *Problem {
+	return New(fiber.StatusServiceUnavailable, code, title)
+}
+
+// Timeout is a request that ran past its deadline. Cause is logged but not sent.
+func Timeout(cause error) *Problem {
+	p := New(fiber.StatusGatewayTimeout, CodeTimeout, "Request timed out").
+		WithDetail("The request did not finish in time and was canceled; try again")
+	p.Cause = cause
+	return p
+}
+
+// From returns the problem an error describes. validation.Errors become a
+// validation problem and fiber errors, such as unknown routes, keep their
+// status. Internal errors become a timeout if the request's deadline passed,
+// or are reported as unavailable if its context was canceled, as when the
+// server shuts down; anything else is an internal error.
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		if p.Status == fiber.StatusInternalServerError && p.Cause != nil {
+			return fromInternal(p.Cause, p)
+		}
+		return p
+	}
+	var invalid validation.Errors
+	if errors.As(err, &invalid) {
+		return Validation(invalid)
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		if fiberErr.Code >= fiber.StatusInternalServerError {
+			return Internal(err, "An unexpected error occurred")
+		}
+		return New(fiberErr.Code, statusCode(fiberErr.Code), utils.StatusMessage(fiberErr.Code)).WithDetail(fiberErr.Message)
+	}
+	return fromInternal(err, Internal(err, "An unexpected error occurred"))
+}
+
+// fromInternal returns the problem an internal error with the given cause
+// stands for, which is internal unless the request ran out of time
+func fromInternal(cause error, internal *Problem) *Problem {
+	switch {
+	case database.IsTimeout(cause):
+		return Timeout(cause)
+	case errors.Is(cause, context.Canceled):
+		p := Unavailable(CodeUnavailable, "Service unavailable").WithDetail("The request was canceled before it finished")
+		p.Cause = cause
+		return p
+	}
+	return internal
+}
+
+// statusCode turns a status into a code, such as "method_not_allowed"
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(utils.StatusMessage(status)), " ", "_")
+}
+
+// Document returns the members of the problem as they are sent to clients
+func (p *Problem) Document() fiber.Map {
+	document := fiber.Map{}
+	for key, value := range p.Extra {
+		document[key] = value
+	}
+	document["type"] = TypeBase + p.Code
+	document["title"] = p.Title
+	document["status"] = p.Status
+	document["code"] = p.Code
+	if p.Detail != "" {
+		document["detail"] = p.Detail
+	}
+	if len(p.Errors) > 0 {
+		document["errors"] = p.Errors
+	}
+	return document
+}
+
+// Handler is a fiber ErrorHandler that sends errors as problem details
+func Handler(c *fiber.Ctx, err error) error {
+	p := From(err)
+	requestID, _ := c.Locals("requestid").(string)
+	var traceID string
+	if spanContext := trace.SpanContextFromContext(c.UserContext()); spanContext.HasTraceID() {
+		traceID = spanContext.TraceID().String()
+	}
+	if p.Status >= fiber.StatusInternalServerError {
+		// The request and trace IDs are added from the context
+		slog.ErrorContext(c.UserContext(), "Internal error", "method", c.Method(), "path", c.Path(), "error", err)
+	}
+
+	document := p.Document()
+	document["instance"] = c.OriginalURL()
+	if requestID != "" {
+		document["request_id"] = requestID
+	}
+	if traceID != "" {
+		document["trace_id"] = traceID
+	}
+	return c.Status(p.Status).JSON(document, ContentType)
+}
@@ -0,0 +1,499 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/audit"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+)
+
+// NewBun returns repositories that store their rows in Postgres through db
+func NewBun(db bun.IDB) Repositories {
+	return Repositories{
+		Products: bunProducts{bunTable[models.Products]{
+			db:         db,
+			entityType: audit.Product,
+			version:    func(product *models.Products) int64 { return product.Version },
+		}},
+		Categories: bunCategories{bunTable[models.Category]{
+			db:         db,
+			entityType: audit.Category,
+			version:    func(category *models.Category) int64 { return category.Version },
+		}},
+		Suppliers: bunSuppliers{bunTable[models.Supplier]{
+			db:         db,
+			entityType: audit.Supplier,
+			version:    func(supplier *models.Supplier) int64 { return supplier.Version },
+		}},
+		Orders: bunOrders{bunTable[models.Orders]{
+			db:         db,
+			entityType: audit.Order,
+		}},
+		Tx: bunTransactor{db},
+	}
+}
+
+// bunTransactor runs transactions on the connection or transaction of the
+// context, retrying them like database.RunInTx
+type bunTransactor struct {
+	db bun.IDB
+}
+
+func (t bunTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.RunInTx(ctx, database.IDB(ctx, t.db), nil, func(ctx context.Context, tx bun.Tx) error {
+		return fn(ctx)
+	})
+}
+
+// sameJSON reports whether two values encode to the same JSON, which treats
+// equal times in different locations as equal
+func sameJSON(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// bunTable implements what the bun repositories have in common for the
+// model T, whose primary key is its id column
+type bunTable[T any] struct {
+	db         bun.IDB
+	entityType string
+	// version returns the row version of versioned models
+	version func(model *T) int64
+}
+
+// idb returns the connection or transaction of ctx
+func (t bunTable[T]) idb(ctx context.Context) bun.IDB {
+	return database.IDB(ctx, t.db)
+}
+
+// check runs a precondition against the version of model
+func (t bunTable[T]) check(model *T, check Precondition) error {
+	if check == nil || t.version == nil {
+		return nil
+	}
+	return check(t.version(model))
+}
+
+// list returns the rows ordered by order
+func (t bunTable[T]) list(ctx context.Context, includeDeleted bool, order string) ([]T, error) {
+	var rows []T
+	query := t.idb(ctx).NewSelect().Model(&rows).Order(order)
+	if includeDeleted {
+		query = query.WhereAllWithDeleted()
+	}
+	return rows, query.Scan(ctx)
+}
+
+func (t bunTable[T]) Get(ctx context.Context, id uuid.UUID) (*T, error) {
+	model := new(T)
+	err := t.idb(ctx).NewSelect().Model(model).Where("?TableAlias.id = ?", id).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (t bunTable[T]) GetDeleted(ctx context.Context, id uuid.UUID) (*T, error) {
+	model := new(T)
+	err := t.idb(ctx).NewSelect().Model(model).WhereDeleted().Where("?TableAlias.id = ?", id).Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (t bunTable[T]) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	return t.idb(ctx).NewSelect().
+		Model((*T)(nil)).
+		Where("?TableAlias.id = ?", id).
+		Apply(tenancy.Scope(ctx)).
+		Exists(ctx)
+}
+
+func (t bunTable[T]) Create(ctx context.Context, model *T) error {
+	return database.RunInTx(ctx, t.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(model).Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Create, t.entityType, audit.EntityID(tx, model), nil, model)
+	})
+}
+
+func (t bunTable[T]) Update(ctx context.Context, model *T, check Precondition) error {
+	return database.RunInTx(ctx, t.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		id := audit.EntityID(tx, model)
+		before := new(T)
+		err := tx.NewSelect().Model(before).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := t.check(before, check); err != nil {
+			return err
+		}
+		// Rows are only deleted and restored by Delete and Restore, and the
+		// version is the trigger's to bump
+		table := tx.Dialect().Tables().Get(reflect.TypeOf(model).Elem())
+		stored, written := reflect.ValueOf(before).Elem(), reflect.ValueOf(model).Elem()
+		for _, field := range []*schema.Field{table.SoftDeleteField, table.FieldMap["version"]} {
+			if field != nil {
+				field.Value(written).Set(field.Value(stored))
+			}
+		}
+		// Returning picks up columns the database sets, such as the new version
+		_, err = tx.NewUpdate().Model(model).WherePK().Returning("*").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, t.entityType, id, before, model)
+	})
+}
+
+// Patch writes only the columns change altered, in one transaction with the
+// audit entry
+func (t bunTable[T]) Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[T]) (*T, error) {
+	row := new(T)
+	err := database.RunInTx(ctx, t.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		stored := new(T)
+		err := tx.NewSelect().Model(stored).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := t.check(stored, check); err != nil {
+			return err
+		}
+		*row = *stored
+		if err := change(ctx, row); err != nil {
+			return err
+		}
+
+		before, after := reflect.ValueOf(stored).Elem(), reflect.ValueOf(row).Elem()
+		table := tx.Dialect().Tables().Get(before.Type())
+		for _, field := range table.Fields {
+			if field.StructField.Tag.Get("json") == "-" {
+				field.Value(after).Set(field.Value(before))
+				continue
+			}
+			immutable := field.IsPK || field.Name == "version" || field == table.SoftDeleteField
+			if immutable && !sameJSON(field.Value(before).Interface(), field.Value(after).Interface()) {
+				return &ReadOnlyError{Column: field.Name}
+			}
+		}
+
+		var changed []string
+		for _, field := range table.DataFields {
+			if !sameJSON(field.Value(before).Interface(), field.Value(after).Interface()) {
+				changed = append(changed, field.Name)
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		// Returning picks up columns the database sets, such as the new version
+		_, err = tx.NewUpdate().Model(row).Column(changed...).WherePK().Returning("*").Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Update, t.entityType, id, stored, row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// Delete removes the row with the given ID; models with a soft_delete column
+// are only marked deleted
+func (t bunTable[T]) Delete(ctx context.Context, id uuid.UUID, check Precondition) error {
+	return database.RunInTx(ctx, t.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		model := new(T)
+		err := tx.NewSelect().Model(model).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := t.check(model, check); err != nil {
+			return err
+		}
+		// A soft delete sets deleted_at on model; the entry shows the row as it was
+		before := *model
+		if _, err := tx.NewDelete().Model(model).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, t.entityType, id, &before, nil)
+	})
+}
+
+func (t bunTable[T]) Restore(ctx context.Context, id uuid.UUID) (*T, error) {
+	model := new(T)
+	err := database.RunInTx(ctx, t.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().Model(model).WhereDeleted().Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		before := *model
+		_, err = tx.NewUpdate().
+			Model(model).
+			WhereDeleted().
+			Set("deleted_at = NULL").
+			WherePK().
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Restore, t.entityType, id, &before, model)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+type bunProducts struct {
+	bunTable[models.Products]
+}
+
+// apply narrows a query on products to the filter
+func (filter ProductFilter) apply(query *bun.SelectQuery) *bun.SelectQuery {
+	if len(filter.CategoryIDs) > 0 {
+		query = query.Where("?TableAlias.category_id IN (?)", bun.In(filter.CategoryIDs))
+	}
+	if filter.SupplierID != uuid.Nil {
+		query = query.Where("?TableAlias.supplier_id = ?", filter.SupplierID)
+	}
+	for name, value := range filter.Attributes {
+		query = query.Where("?TableAlias.attributes ->> ? = ?", name, value)
+	}
+	if filter.IncludeDeleted {
+		query = query.WhereAllWithDeleted()
+	}
+	return query
+}
+
+func (r bunProducts) List(ctx context.Context, filter ProductFilter) ([]models.Products, error) {
+	var products []models.Products
+	err := r.idb(ctx).NewSelect().
+		Model(&products).
+		Relation("Category").
+		Relation("Supplier").
+		Apply(filter.apply).
+		Order("products.name").
+		Scan(ctx)
+	return products, err
+}
+
+func (r bunProducts) Count(ctx context.Context, filter ProductFilter) (int, error) {
+	return r.idb(ctx).NewSelect().
+		Model((*models.Products)(nil)).
+		Apply(tenancy.Scope(ctx)).
+		Apply(filter.apply).
+		Count(ctx)
+}
+
+func (r bunProducts) Get(ctx context.Context, id uuid.UUID) (*models.Products, error) {
+	var product models.Products
+	err := r.idb(ctx).NewSelect().
+		Model(&product).
+		Relation("Category").
+		Relation("Supplier").
+		Where("products.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+type bunCategories struct {
+	bunTable[models.Category]
+}
+
+func (r bunCategories) List(ctx context.Context, includeDeleted bool) ([]models.Category, error) {
+	return r.list(ctx, includeDeleted, "name")
+}
+
+// Move runs serializable, so that two concurrent moves cannot close a cycle
+// between them
+func (r bunCategories) Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, check Precondition) (*models.Category, error) {
+	var category *models.Category
+	err := database.RunInTx(ctx, r.idb(ctx), &sql.TxOptions{Isolation: sql.LevelSerializable}, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		category, err = r.Patch(ctx, id, check, moveTo(r, id, parentID))
+		return err
+	})
+	return category, err
+}
+
+// Delete records an audit entry for every child and product it moves
+func (r bunCategories) Delete(ctx context.Context, id uuid.UUID, check Precondition, reassign Reassign) error {
+	return database.RunInTx(ctx, r.idb(ctx), nil, func(ctx context.Context, tx bun.Tx) error {
+		var category models.Category
+		err := tx.NewSelect().Model(&category).Where("?TableAlias.id = ?", id).For("UPDATE").Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if err := r.check(&category, check); err != nil {
+			return err
+		}
+
+		var contents CategoryContents
+		contents.Children, err = tx.NewSelect().Model((*models.Category)(nil)).Where("parent_id = ?", id).Apply(tenancy.Scope(ctx)).Count(ctx)
+		if err != nil {
+			return err
+		}
+		contents.Products, err = tx.NewSelect().Model((*models.Products)(nil)).Where("category_id = ?", id).Apply(tenancy.Scope(ctx)).Count(ctx)
+		if err != nil {
+			return err
+		}
+		if contents.Children > 0 || contents.Products > 0 {
+			if err := r.reassign(ctx, tx, &category, contents, reassign); err != nil {
+				return err
+			}
+		}
+
+		before := category
+		if _, err := tx.NewDelete().Model(&category).WherePK().Exec(ctx); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, audit.Delete, audit.Category, category.ID, &before, nil)
+	})
+}
+
+// reassign moves the children and products of a category to where reassign says
+func (r bunCategories) reassign(ctx context.Context, tx bun.Tx, category *models.Category, contents CategoryContents, reassign Reassign) error {
+	target, err := reassign(ctx, category, contents)
+	if err != nil {
+		return err
+	}
+	if contents.Products > 0 && target == nil {
+		return errNoTarget
+	}
+
+	var movedChildren, movedProducts []uuid.UUID
+	_, err = tx.NewUpdate().
+		Model((*models.Category)(nil)).
+		Set("parent_id = ?", target).
+		Where("parent_id = ?", category.ID).
+		Returning("id").
+		Exec(ctx, &movedChildren)
+	if err != nil {
+		return err
+	}
+	for _, child := range movedChildren {
+		err := audit.Record(ctx, tx, audit.Update, audit.Category, child,
+			map[string]interface{}{"parent_id": category.ID}, map[string]interface{}{"parent_id": target})
+		if err != nil {
+			return err
+		}
+	}
+	if contents.Products > 0 {
+		_, err = tx.NewUpdate().
+			Model((*models.Products)(nil)).
+			Set("category_id = ?", *target).
+			Where("category_id = ?", category.ID).
+			Returning("id").
+			Exec(ctx, &movedProducts)
+		if err != nil {
+			return err
+		}
+	}
+	for _, product := range movedProducts {
+		err := audit.Record(ctx, tx, audit.Update, audit.Product, product,
+			map[string]interface{}{"category_id": category.ID}, map[string]interface{}{"category_id": target})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r bunCategories) SubtreeIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	// Raw queries bypass the model hooks, so the tenant is filtered explicitly
+	tenantID, _ := tenancy.FromContext(ctx)
+	var ids []uuid.UUID
+	err := r.idb(ctx).NewRaw(`
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM categories WHERE id = ? AND tenant_id = ?
+			UNION ALL
+			SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id WHERE c.tenant_id = ?
+		)
+		SELECT id FROM subtree`, id, tenantID, tenantID).
+		Scan(ctx, &ids)
+	return ids, err
+}
+
+func (r bunCategories) Attributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error) {
+	tenantID, _ := tenancy.FromContext(ctx)
+	var categoryIDs []uuid.UUID
+	err := r.idb(ctx).NewRaw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM categories WHERE id = ? AND tenant_id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id WHERE c.tenant_id = ?
+		)
+		SELECT id FROM ancestors`, id, tenantID, tenantID).
+		Scan(ctx, &categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+	return r.attributesOf(ctx, categoryIDs)
+}
+
+func (r bunCategories) SubtreeAttributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error) {
+	categoryIDs, err := r.SubtreeIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return r.attributesOf(ctx, categoryIDs)
+}
+
+// attributesOf returns the attributes defined on the given categories by name
+func (r bunCategories) attributesOf(ctx context.Context, categoryIDs []uuid.UUID) ([]models.CategoryAttribute, error) {
+	if len(categoryIDs) == 0 {
+		return nil, nil
+	}
+	var definitions []models.CategoryAttribute
+	err := r.idb(ctx).NewSelect().
+		Model(&definitions).
+		Where("ca.category_id IN (?)", bun.In(categoryIDs)).
+		Order("ca.name").
+		Scan(ctx)
+	return definitions, err
+}
+
+type bunSuppliers struct {
+	bunTable[models.Supplier]
+}
+
+func (r bunSuppliers) List(ctx context.Context, includeDeleted bool) ([]models.Supplier, error) {
+	return r.list(ctx, includeDeleted, "name")
+}
+
+type bunOrders struct {
+	bunTable[models.Orders]
+}
+
+func (r bunOrders) List(ctx context.Context) ([]models.Orders, error) {
+	return r.list(ctx, false, "order_date")
+}
+
+func (r bunOrders) Update(ctx context.Context, order *models.Orders) error {
+	return r.bunTable.Update(ctx, order, nil)
+}
+
+func (r bunOrders) Patch(ctx context.Context, id uuid.UUID, change Change[models.Orders]) (*models.Orders, error) {
+	return r.bunTable.Patch(ctx, id, nil, change)
+}
+
+func (r bunOrders) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.bunTable.Delete(ctx, id, nil)
+}
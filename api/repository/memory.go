@@ -0,0 +1,699 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tenancy"
+	"github.com/google/uuid"
+)
+
+// Memory holds the rows of the in-memory repositories. It keeps tenants
+// apart and implements row versions, soft deletes and the unique indexes on
+// category and supplier names, but no other constraints, and it records no
+// audit log. Its transactions undo their writes on error but are not isolated
+// from each other.
+type Memory struct {
+	mu         sync.Mutex
+	products   map[uuid.UUID]*models.Products
+	categories map[uuid.UUID]*models.Category
+	suppliers  map[uuid.UUID]*models.Supplier
+	orders     map[uuid.UUID]*models.Orders
+	attributes []models.CategoryAttribute
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		products:   map[uuid.UUID]*models.Products{},
+		categories: map[uuid.UUID]*models.Category{},
+		suppliers:  map[uuid.UUID]*models.Supplier{},
+		orders:     map[uuid.UUID]*models.Orders{},
+	}
+}
+
+// Repositories returns repositories that share the rows of m
+func (m *Memory) Repositories() Repositories {
+	return Repositories{
+		Products: memoryProducts{m, memoryTable[models.Products]{
+			mu:   &m.mu,
+			rows: m.products,
+			less: func(a, b *models.Products) bool { return a.Name < b.Name },
+		}},
+		Categories: memoryCategories{m, memoryTable[models.Category]{
+			mu:    &m.mu,
+			rows:  m.categories,
+			table: "categories",
+			less:  func(a, b *models.Category) bool { return a.Name < b.Name },
+			unique: func(category *models.Category) map[string]string {
+				return map[string]string{"categories_name_key": strings.ToLower(category.Name)}
+			},
+		}},
+		Suppliers: memorySuppliers{memoryTable[models.Supplier]{
+			mu:    &m.mu,
+			rows:  m.suppliers,
+			table: "suppliers",
+			less:  func(a, b *models.Supplier) bool { return a.Name < b.Name },
+			unique: func(supplier *models.Supplier) map[string]string {
+				keys := map[string]string{"suppliers_name_key": strings.ToLower(supplier.Name)}
+				if supplier.Email != "" {
+					keys["suppliers_email_key"] = strings.ToLower(supplier.Email)
+				}
+				return keys
+			},
+		}},
+		Orders: memoryOrders{memoryTable[models.Orders]{
+			mu:   &m.mu,
+			rows: m.orders,
+			less: func(a, b *models.Orders) bool { return a.OrderDate.Before(b.OrderDate) },
+		}},
+		Tx: m,
+	}
+}
+
+// InTx puts the rows back as they were before fn if it fails. Writes made
+// meanwhile by calls outside fn are undone too.
+func (m *Memory) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.mu.Lock()
+	products, categories := copyRows(m.products), copyRows(m.categories)
+	suppliers, orders := copyRows(m.suppliers), copyRows(m.orders)
+	attributes := slices.Clone(m.attributes)
+	m.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		m.mu.Lock()
+		// The tables share the maps, which must stay the same
+		restoreRows(m.products, products)
+		restoreRows(m.categories, categories)
+		restoreRows(m.suppliers, suppliers)
+		restoreRows(m.orders, orders)
+		m.attributes = attributes
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// copyRows returns a copy of rows that shares none of its rows
+func copyRows[T any](rows map[uuid.UUID]*T) map[uuid.UUID]*T {
+	copied := make(map[uuid.UUID]*T, len(rows))
+	for id, row := range rows {
+		saved := *row
+		copied[id] = &saved
+	}
+	return copied
+}
+
+// restoreRows replaces the rows of a table with saved ones
+func restoreRows[T any](rows, saved map[uuid.UUID]*T) {
+	clear(rows)
+	maps.Copy(rows, saved)
+}
+
+// DefineAttribute adds an attribute definition to a category of the tenant in
+// ctx, which the in-memory repositories cannot do otherwise
+func (m *Memory) DefineAttribute(ctx context.Context, attribute models.CategoryAttribute) error {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attribute.ID == uuid.Nil {
+		attribute.ID = uuid.New()
+	}
+	attribute.TenantID = tenantID
+	m.attributes = append(m.attributes, attribute)
+	return nil
+}
+
+// memoryTable implements what the in-memory repositories have in common for
+// the model T. Rows are read and written through reflection on the fields
+// the models share: ID (or Id), TenantID and, where the model has them,
+// Version and DeletedAt.
+type memoryTable[T any] struct {
+	mu   *sync.Mutex
+	rows map[uuid.UUID]*T
+	// less orders the rows of List
+	less func(a, b *T) bool
+	// table and unique describe unique indexes: unique returns the key of
+	// each index for a row, which must not repeat among the tenant's rows
+	// that are not deleted
+	table  string
+	unique func(row *T) map[string]string
+}
+
+// field returns a field of a row, or an invalid Value if it has none
+func field[T any](row *T, name string) reflect.Value {
+	return reflect.ValueOf(row).Elem().FieldByName(name)
+}
+
+func rowID[T any](row *T) reflect.Value {
+	if id := field(row, "ID"); id.IsValid() {
+		return id
+	}
+	return field(row, "Id")
+}
+
+func isDeleted[T any](row *T) bool {
+	deletedAt := field(row, "DeletedAt")
+	return deletedAt.IsValid() && !deletedAt.Interface().(time.Time).IsZero()
+}
+
+// find returns the stored row with the given ID if it belongs to the tenant
+// in ctx and is deleted or not as asked. The caller must hold the lock.
+func (t memoryTable[T]) find(ctx context.Context, id uuid.UUID, deleted bool) (*T, error) {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+	row, ok := t.rows[id]
+	if !ok || field(row, "TenantID").Interface() != tenantID || isDeleted(row) != deleted {
+		return nil, ErrNotFound
+	}
+	return row, nil
+}
+
+// checkUnique returns a duplicate key error if row repeats the key of a
+// unique index. The caller must hold the lock.
+func (t memoryTable[T]) checkUnique(row *T) error {
+	if t.unique == nil {
+		return nil
+	}
+	keys := t.unique(row)
+	for _, other := range t.rows {
+		if isDeleted(other) || rowID(other).Interface() == rowID(row).Interface() ||
+			field(other, "TenantID").Interface() != field(row, "TenantID").Interface() {
+			continue
+		}
+		for constraint, key := range t.unique(other) {
+			if keys[constraint] == key {
+				return &database.Error{
+					Kind:       database.ErrDuplicate,
+					Table:      t.table,
+					Constraint: constraint,
+					Err:        fmt.Errorf("duplicate key value violates unique constraint %q", constraint),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// check runs a precondition against the version of row
+func (t memoryTable[T]) check(row *T, check Precondition) error {
+	version := field(row, "Version")
+	if check == nil || !version.IsValid() {
+		return nil
+	}
+	return check(version.Int())
+}
+
+func (t memoryTable[T]) list(ctx context.Context, includeDeleted bool) ([]T, error) {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rows []T
+	for _, row := range t.rows {
+		if field(row, "TenantID").Interface() == tenantID && (includeDeleted || !isDeleted(row)) {
+			rows = append(rows, *row)
+		}
+	}
+	slices.SortFunc(rows, func(a, b T) int {
+		switch {
+		case t.less(&a, &b):
+			return -1
+		case t.less(&b, &a):
+			return 1
+		}
+		return 0
+	})
+	return rows, nil
+}
+
+func (t memoryTable[T]) Get(ctx context.Context, id uuid.UUID) (*T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, err := t.find(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	copied := *row
+	return &copied, nil
+}
+
+func (t memoryTable[T]) GetDeleted(ctx context.Context, id uuid.UUID) (*T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, err := t.find(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	copied := *row
+	return &copied, nil
+}
+
+func (t memoryTable[T]) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	_, err := t.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t memoryTable[T]) Create(ctx context.Context, model *T) error {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return tenancy.ErrNoTenant
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row := *model
+	if rowID(&row).Interface() == uuid.Nil {
+		rowID(&row).Set(reflect.ValueOf(uuid.New()))
+	}
+	field(&row, "TenantID").Set(reflect.ValueOf(tenantID))
+	if version := field(&row, "Version"); version.IsValid() {
+		version.SetInt(1)
+	}
+	if err := t.checkUnique(&row); err != nil {
+		return err
+	}
+	t.rows[rowID(&row).Interface().(uuid.UUID)] = &row
+	*model = row
+	return nil
+}
+
+func (t memoryTable[T]) Update(ctx context.Context, model *T, check Precondition) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before, err := t.find(ctx, rowID(model).Interface().(uuid.UUID), false)
+	if err != nil {
+		return err
+	}
+	if err := t.check(before, check); err != nil {
+		return err
+	}
+
+	row := *model
+	field(&row, "TenantID").Set(field(before, "TenantID"))
+	if deletedAt := field(&row, "DeletedAt"); deletedAt.IsValid() {
+		deletedAt.Set(field(before, "DeletedAt"))
+	}
+	// Like the database, only a change to the row makes a new version
+	if version := field(&row, "Version"); version.IsValid() {
+		version.SetInt(field(before, "Version").Int())
+		if !reflect.DeepEqual(row, *before) {
+			version.SetInt(version.Int() + 1)
+		}
+	}
+	if err := t.checkUnique(&row); err != nil {
+		return err
+	}
+	*before = row
+	*model = row
+	return nil
+}
+
+// Patch only checks the change once change has returned, without holding the
+// lock, since change may call the repositories
+func (t memoryTable[T]) Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[T]) (*T, error) {
+	t.mu.Lock()
+	stored, err := t.find(ctx, id, false)
+	if err == nil {
+		err = t.check(stored, check)
+	}
+	if err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+	before := *stored
+	t.mu.Unlock()
+
+	row := before
+	if err := change(ctx, &row); err != nil {
+		return nil, err
+	}
+	readOnly := []struct {
+		column        string
+		stored, after reflect.Value
+	}{
+		{"id", rowID(&before), rowID(&row)},
+		{"version", field(&before, "Version"), field(&row, "Version")},
+		{"deleted_at", field(&before, "DeletedAt"), field(&row, "DeletedAt")},
+	}
+	for _, value := range readOnly {
+		if value.after.IsValid() && !sameJSON(value.stored.Interface(), value.after.Interface()) {
+			return nil, &ReadOnlyError{Column: value.column}
+		}
+	}
+	field(&row, "TenantID").Set(field(&before, "TenantID"))
+
+	if err := t.Update(ctx, &row, nil); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// Delete removes the row with the given ID; models with a DeletedAt field are
+// only marked deleted
+func (t memoryTable[T]) Delete(ctx context.Context, id uuid.UUID, check Precondition) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row, err := t.find(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	if err := t.check(row, check); err != nil {
+		return err
+	}
+	if deletedAt := field(row, "DeletedAt"); deletedAt.IsValid() {
+		deletedAt.Set(reflect.ValueOf(time.Now()))
+		return nil
+	}
+	delete(t.rows, id)
+	return nil
+}
+
+func (t memoryTable[T]) Restore(ctx context.Context, id uuid.UUID) (*T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	row, err := t.find(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	restored := *row
+	field(&restored, "DeletedAt").Set(reflect.ValueOf(time.Time{}))
+	if err := t.checkUnique(&restored); err != nil {
+		return nil, err
+	}
+	*row = restored
+	return &restored, nil
+}
+
+type memoryProducts struct {
+	store *Memory
+	memoryTable[models.Products]
+}
+
+// matches reports whether a product passes the filter
+func (filter ProductFilter) matches(product *models.Products) bool {
+	if len(filter.CategoryIDs) > 0 && !slices.Contains(filter.CategoryIDs, product.CategoryID) {
+		return false
+	}
+	if filter.SupplierID != uuid.Nil && product.SupplierID != filter.SupplierID {
+		return false
+	}
+	for name, value := range filter.Attributes {
+		attribute, ok := product.Attributes[name]
+		if !ok || attribute == nil || fmt.Sprint(attribute) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// withRelations loads the category and supplier of a product
+func (r memoryProducts) withRelations(product *models.Products) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if category, ok := r.store.categories[product.CategoryID]; ok {
+		product.Category = *category
+	}
+	if supplier, ok := r.store.suppliers[product.SupplierID]; ok {
+		product.Supplier = *supplier
+	}
+}
+
+func (r memoryProducts) List(ctx context.Context, filter ProductFilter) ([]models.Products, error) {
+	products, err := r.list(ctx, filter.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	var matching []models.Products
+	for i := range products {
+		if filter.matches(&products[i]) {
+			r.withRelations(&products[i])
+			matching = append(matching, products[i])
+		}
+	}
+	return matching, nil
+}
+
+func (r memoryProducts) Count(ctx context.Context, filter ProductFilter) (int, error) {
+	products, err := r.list(ctx, filter.IncludeDeleted)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range products {
+		if filter.matches(&products[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryProducts) Get(ctx context.Context, id uuid.UUID) (*models.Products, error) {
+	product, err := r.memoryTable.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.withRelations(product)
+	return product, nil
+}
+
+// Update stores the product without its relations, which are not columns
+func (r memoryProducts) Update(ctx context.Context, product *models.Products, check Precondition) error {
+	row := *product
+	row.Category, row.Supplier = models.Category{}, models.Supplier{}
+	if err := r.memoryTable.Update(ctx, &row, check); err != nil {
+		return err
+	}
+	product.TenantID, product.Version = row.TenantID, row.Version
+	return nil
+}
+
+// Create stores the product without its relations, which are not columns
+func (r memoryProducts) Create(ctx context.Context, product *models.Products) error {
+	row := *product
+	row.Category, row.Supplier = models.Category{}, models.Supplier{}
+	if err := r.memoryTable.Create(ctx, &row); err != nil {
+		return err
+	}
+	product.ID, product.TenantID, product.Version = row.ID, row.TenantID, row.Version
+	return nil
+}
+
+// Patch stores the product without its relations, which are not columns
+func (r memoryProducts) Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[models.Products]) (*models.Products, error) {
+	product, err := r.memoryTable.Patch(ctx, id, check, func(ctx context.Context, product *models.Products) error {
+		if err := change(ctx, product); err != nil {
+			return err
+		}
+		product.Category, product.Supplier = models.Category{}, models.Supplier{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.withRelations(product)
+	return product, nil
+}
+
+type memoryCategories struct {
+	store *Memory
+	memoryTable[models.Category]
+}
+
+func (r memoryCategories) Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, check Precondition) (*models.Category, error) {
+	return r.Patch(ctx, id, check, moveTo(r, id, parentID))
+}
+
+// Delete calls reassign without holding the lock, since it may call the
+// repositories
+func (r memoryCategories) Delete(ctx context.Context, id uuid.UUID, check Precondition, reassign Reassign) error {
+	r.mu.Lock()
+	category, err := r.find(ctx, id, false)
+	if err == nil {
+		err = r.check(category, check)
+	}
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	stored := *category
+	contents := r.contents(id)
+	r.mu.Unlock()
+
+	var target *uuid.UUID
+	if contents.Children > 0 || contents.Products > 0 {
+		target, err = reassign(ctx, &stored, contents)
+		if err != nil {
+			return err
+		}
+		if contents.Products > 0 && target == nil {
+			return errNoTarget
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	category, err = r.find(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	for _, child := range r.rows {
+		if child.TenantID == category.TenantID && !isDeleted(child) && child.ParentID != nil && *child.ParentID == id {
+			child.ParentID = target
+			child.Version++
+		}
+	}
+	for _, product := range r.store.products {
+		if product.TenantID == category.TenantID && !isDeleted(product) && product.CategoryID == id {
+			product.CategoryID = *target
+			product.Version++
+		}
+	}
+	category.DeletedAt = time.Now()
+	return nil
+}
+
+// contents counts what a category holds. The caller must hold the lock.
+func (r memoryCategories) contents(id uuid.UUID) CategoryContents {
+	var contents CategoryContents
+	for _, child := range r.rows {
+		if !isDeleted(child) && child.ParentID != nil && *child.ParentID == id {
+			contents.Children++
+		}
+	}
+	for _, product := range r.store.products {
+		if !isDeleted(product) && product.CategoryID == id {
+			contents.Products++
+		}
+	}
+	return contents
+}
+
+func (r memoryCategories) List(ctx context.Context, includeDeleted bool) ([]models.Category, error) {
+	return r.list(ctx, includeDeleted)
+}
+
+// SubtreeIDs includes deleted categories, like the recursive query of the bun
+// repository
+func (r memoryCategories) SubtreeIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if category, ok := r.rows[id]; !ok || category.TenantID != tenantID {
+		return nil, nil
+	}
+	ids := []uuid.UUID{id}
+	for i := 0; i < len(ids); i++ {
+		for _, category := range r.rows {
+			if category.TenantID == tenantID && category.ParentID != nil && *category.ParentID == ids[i] {
+				ids = append(ids, category.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (r memoryCategories) Attributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error) {
+	tenantID, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ancestors := map[uuid.UUID]bool{}
+	for category, ok := r.rows[id]; ok && category.TenantID == tenantID && !ancestors[category.ID]; {
+		ancestors[category.ID] = true
+		if category.ParentID == nil {
+			break
+		}
+		category, ok = r.rows[*category.ParentID]
+	}
+	return r.attributesOf(tenantID, ancestors), nil
+}
+
+func (r memoryCategories) SubtreeAttributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error) {
+	ids, err := r.SubtreeIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	tenantID, _ := tenancy.FromContext(ctx)
+	subtree := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		subtree[id] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attributesOf(tenantID, subtree), nil
+}
+
+// attributesOf returns the attributes defined on the given categories by
+// name. The caller must hold the lock.
+func (r memoryCategories) attributesOf(tenantID uuid.UUID, categories map[uuid.UUID]bool) []models.CategoryAttribute {
+	var definitions []models.CategoryAttribute
+	for _, attribute := range r.store.attributes {
+		if attribute.TenantID == tenantID && categories[attribute.CategoryID] {
+			definitions = append(definitions, attribute)
+		}
+	}
+	slices.SortFunc(definitions, func(a, b models.CategoryAttribute) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return definitions
+}
+
+type memorySuppliers struct {
+	memoryTable[models.Supplier]
+}
+
+func (r memorySuppliers) List(ctx context.Context, includeDeleted bool) ([]models.Supplier, error) {
+	return r.list(ctx, includeDeleted)
+}
+
+type memoryOrders struct {
+	memoryTable[models.Orders]
+}
+
+func (r memoryOrders) List(ctx context.Context) ([]models.Orders, error) {
+	return r.list(ctx, false)
+}
+
+func (r memoryOrders) Update(ctx context.Context, order *models.Orders) error {
+	return r.memoryTable.Update(ctx, order, nil)
+}
+
+func (r memoryOrders) Patch(ctx context.Context, id uuid.UUID, change Change[models.Orders]) (*models.Orders, error) {
+	return r.memoryTable.Patch(ctx, id, nil, change)
+}
+
+func (r memoryOrders) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.memoryTable.Delete(ctx, id, nil)
+}
@@ -0,0 +1,272 @@
+// Package repository is the storage of products, categories, suppliers and
+// orders behind the handlers.
+//
+// NewBun stores them in Postgres through bun; NewMemory keeps them in memory,
+// so handlers can be tested without a database. Both limit every operation to
+// the tenant in the context (see tenancy.WithTenant) and report missing rows
+// with ErrNotFound.
+//
+// The bun repositories run their queries on the connection or transaction
+// stored in the context with database.WithIDB, falling back to the one they
+// were created with. Writes record their audit entry in the same transaction,
+// taking the actor from the context (see audit.WithMetadata).
+//
+// Only those four are behind repositories. The other handlers, such as those
+// of kits, units, images, attributes, users, tenants, API keys, the audit log,
+// KPIs and health, still build bun queries themselves and need a database in
+// tests.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/models"
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for rows that do not exist in the caller's tenant.
+// It is sql.ErrNoRows, so either can be tested for.
+var ErrNotFound = sql.ErrNoRows
+
+var (
+	// ErrParentNotFound is returned for a parent category that does not exist
+	ErrParentNotFound = errors.New("parent category not found")
+	// ErrCategoryCycle is returned for a parent category that lies in the
+	// subtree of the category it would contain
+	ErrCategoryCycle = errors.New("category cycle")
+)
+
+// Precondition checks the version of a row about to be written, such as
+// against a request's If-Match. Its error is returned unchanged and nothing is
+// written. A nil Precondition accepts every version.
+type Precondition func(version int64) error
+
+// Change edits a row loaded for writing, given as a copy of the stored one.
+// Its error is returned unchanged and nothing is written.
+type Change[T any] func(ctx context.Context, row *T) error
+
+// ReadOnlyError is returned by Patch for a change to a column that cannot be
+// written: the ID, the version or the deletion time
+type ReadOnlyError struct {
+	Column string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return e.Column + " cannot be changed"
+}
+
+// Transactor runs fn in a transaction. The repositories run every call made
+// with the context fn is given in that transaction, so an error from fn
+// undoes all of their writes. Transactions can be nested.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ProductFilter selects products. Zero fields match every product.
+type ProductFilter struct {
+	// CategoryIDs matches products in any of these categories
+	CategoryIDs []uuid.UUID
+	SupplierID  uuid.UUID
+	// Attributes matches products whose attributes have these values,
+	// compared as text
+	Attributes map[string]string
+	// IncludeDeleted also matches soft-deleted products
+	IncludeDeleted bool
+}
+
+// ProductRepository stores products. Products are soft-deleted.
+type ProductRepository interface {
+	// List returns the products matching filter by name, with their
+	// category and supplier
+	List(ctx context.Context, filter ProductFilter) ([]models.Products, error)
+	// Count returns the number of products matching filter
+	Count(ctx context.Context, filter ProductFilter) (int, error)
+	// Get returns a product with its category and supplier
+	Get(ctx context.Context, id uuid.UUID) (*models.Products, error)
+	// GetDeleted returns a soft-deleted product
+	GetDeleted(ctx context.Context, id uuid.UUID) (*models.Products, error)
+	// Create stores a new product, filling in its ID and version
+	Create(ctx context.Context, product *models.Products) error
+	// Update writes a product over the stored one with its ID and loads the
+	// new version
+	Update(ctx context.Context, product *models.Products, check Precondition) error
+	// Patch loads a product for writing, lets change edit it and writes the
+	// columns that changed, returning the product with its new version.
+	// Columns hidden from JSON, such as the tenant, keep their stored value.
+	Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[models.Products]) (*models.Products, error)
+	// Delete marks a product deleted
+	Delete(ctx context.Context, id uuid.UUID, check Precondition) error
+	// Restore undoes the deletion of a product
+	Restore(ctx context.Context, id uuid.UUID) (*models.Products, error)
+}
+
+// CategoryRepository stores categories. Categories are soft-deleted, and the
+// names of the categories that are not must be unique.
+type CategoryRepository interface {
+	// List returns the categories by name
+	List(ctx context.Context, includeDeleted bool) ([]models.Category, error)
+	Get(ctx context.Context, id uuid.UUID) (*models.Category, error)
+	// GetDeleted returns a soft-deleted category
+	GetDeleted(ctx context.Context, id uuid.UUID) (*models.Category, error)
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+	// SubtreeIDs returns the ID of a category and of all its descendants, or
+	// nothing if there is no such category
+	SubtreeIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	// Attributes returns the attributes products in a category may carry by
+	// name, including those inherited from its ancestors
+	Attributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error)
+	// SubtreeAttributes returns the attributes defined on a category and on
+	// its descendants, which inherit the category's, by name
+	SubtreeAttributes(ctx context.Context, id uuid.UUID) ([]models.CategoryAttribute, error)
+	// Create stores a new category, filling in its ID and version
+	Create(ctx context.Context, category *models.Category) error
+	// Update writes a category over the stored one with its ID and loads
+	// the new version
+	Update(ctx context.Context, category *models.Category, check Precondition) error
+	// Patch works like ProductRepository.Patch
+	Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[models.Category]) (*models.Category, error)
+	// Move puts a category, together with its subtree, under a new parent,
+	// or at the root for nil. The parent is checked with CheckParent.
+	Move(ctx context.Context, id uuid.UUID, parentID *uuid.UUID, check Precondition) (*models.Category, error)
+	// Delete marks a category deleted. Its children and products are first
+	// moved to where reassign says.
+	Delete(ctx context.Context, id uuid.UUID, check Precondition, reassign Reassign) error
+	// Restore undoes the deletion of a category
+	Restore(ctx context.Context, id uuid.UUID) (*models.Category, error)
+}
+
+// CategoryContents counts the categories and products in a category that are
+// not deleted
+type CategoryContents struct {
+	Children int
+	Products int
+}
+
+// Reassign decides where the children and products of a category about to be
+// deleted go: under the returned category, or for children to the root if it
+// is nil. It is only called for categories that are not empty, in the
+// transaction of the delete, and its error is returned unchanged with nothing
+// written.
+type Reassign func(ctx context.Context, category *models.Category, contents CategoryContents) (*uuid.UUID, error)
+
+// AttributeClashError is returned for a parent under which a category, or one
+// of its descendants, would inherit an attribute with the name of one it
+// defines. Names must be unique along every ancestor chain.
+type AttributeClashError struct {
+	Name string
+}
+
+func (e *AttributeClashError) Error() string {
+	return "attribute " + e.Name + " is already defined for the new parent or one of its ancestors"
+}
+
+// errNoTarget is returned when products would be left without a category
+var errNoTarget = errors.New("products of a deleted category need a category to move to")
+
+// CheckParent verifies that parentID, unless nil, names a category outside
+// the subtree of the category with the given ID, which would otherwise become
+// its own ancestor, and whose attributes the subtree can inherit without an
+// AttributeClashError. A nil ID stands for a new category.
+func CheckParent(ctx context.Context, categories CategoryRepository, id uuid.UUID, parentID *uuid.UUID) error {
+	if parentID == nil {
+		return nil
+	}
+
+	exists, err := categories.Exists(ctx, *parentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrParentNotFound
+	}
+
+	if id == uuid.Nil {
+		return nil
+	}
+	subtree, err := categories.SubtreeIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, descendant := range subtree {
+		if descendant == *parentID {
+			return ErrCategoryCycle
+		}
+	}
+
+	inherited, err := categories.Attributes(ctx, *parentID)
+	if err != nil {
+		return err
+	}
+	defined, err := categories.SubtreeAttributes(ctx, id)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(inherited))
+	for _, definition := range inherited {
+		names[definition.Name] = true
+	}
+	for _, definition := range defined {
+		if names[definition.Name] {
+			return &AttributeClashError{Name: definition.Name}
+		}
+	}
+	return nil
+}
+
+// moveTo changes the parent of the category with the given ID
+func moveTo(categories CategoryRepository, id uuid.UUID, parentID *uuid.UUID) Change[models.Category] {
+	return func(ctx context.Context, category *models.Category) error {
+		if err := CheckParent(ctx, categories, id, parentID); err != nil {
+			return err
+		}
+		category.ParentID = parentID
+		return nil
+	}
+}
+
+// SupplierRepository stores suppliers. Suppliers are soft-deleted, and the
+// names and emails of the suppliers that are not must be unique.
+type SupplierRepository interface {
+	// List returns the suppliers by name
+	List(ctx context.Context, includeDeleted bool) ([]models.Supplier, error)
+	Get(ctx context.Context, id uuid.UUID) (*models.Supplier, error)
+	Exists(ctx context.Context, id uuid.UUID) (bool, error)
+	// Create stores a new supplier, filling in its ID and version
+	Create(ctx context.Context, supplier *models.Supplier) error
+	// Update writes a supplier over the stored one with its ID and loads
+	// the new version
+	Update(ctx context.Context, supplier *models.Supplier, check Precondition) error
+	// Patch works like ProductRepository.Patch
+	Patch(ctx context.Context, id uuid.UUID, check Precondition, change Change[models.Supplier]) (*models.Supplier, error)
+	// Delete marks a supplier deleted
+	Delete(ctx context.Context, id uuid.UUID, check Precondition) error
+	// Restore undoes the deletion of a supplier
+	Restore(ctx context.Context, id uuid.UUID) (*models.Supplier, error)
+}
+
+// OrderRepository stores orders. Orders are not versioned and are deleted
+// for good.
+type OrderRepository interface {
+	// List returns the orders by date
+	List(ctx context.Context) ([]models.Orders, error)
+	Get(ctx context.Context, id uuid.UUID) (*models.Orders, error)
+	// Create stores a new order, filling in its ID
+	Create(ctx context.Context, order *models.Orders) error
+	// Update writes an order over the stored one with its ID
+	Update(ctx context.Context, order *models.Orders) error
+	// Patch works like ProductRepository.Patch
+	Patch(ctx context.Context, id uuid.UUID, change Change[models.Orders]) (*models.Orders, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// Repositories are the repositories the handlers use, backed by one store
+type Repositories struct {
+	Products   ProductRepository
+	Categories CategoryRepository
+	Suppliers  SupplierRepository
+	Orders     OrderRepository
+	// Tx runs calls to the repositories in one transaction
+	Tx Transactor
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned when no object is stored under a key
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores uploaded files such as product images
+type Storage interface {
+	// Put stores size bytes from r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Missing objects are not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can fetch the object from
+	URL(key string) string
+}
+
+// Config selects and sets up a storage backend
+type Config struct {
+	// Backend is "local" or "s3"
+	Backend string
+	// LocalDir is the directory of the local backend, default "uploads"
+	LocalDir string
+	// PublicURL is the URL prefix images are served from, default "/uploads"
+	// for the local backend
+	PublicURL string
+	S3        S3Config
+}
+
+// New creates the storage backend selected by config.Backend
+func New(config Config) (Storage, error) {
+	switch config.Backend {
+	case "", "local":
+		dir := config.LocalDir
+		if dir == "" {
+			dir = "uploads"
+		}
+		publicPath := config.PublicURL
+		if publicPath == "" {
+			publicPath = "/uploads"
+		}
+		return NewLocalStorage(dir, publicPath)
+	case "s3":
+		s3 := config.S3
+		s3.PublicURL = config.PublicURL
+		return NewS3Storage(s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
+	}
+}
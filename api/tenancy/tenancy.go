@@ -0,0 +1,45 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// ErrNoTenant is returned when tenant-owned data is queried without a tenant in the context
+var ErrNoTenant = errors.New("no tenant in context")
+
+// DefaultTenantID owns the rows that existed before multi-tenancy was introduced
+var DefaultTenantID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// SettingName is the Postgres setting row-level security policies compare tenant_id against
+const SettingName = "app.tenant_id"
+
+type contextKey struct{}
+
+// WithTenant returns a context whose queries are limited to one tenant
+func WithTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// FromContext returns the tenant stored by WithTenant
+func FromContext(ctx context.Context) (uuid.UUID, bool) {
+	tenantID, ok := ctx.Value(contextKey{}).(uuid.UUID)
+	return tenantID, ok && tenantID != uuid.Nil
+}
+
+// Scope limits any query on a tenant-owned model to the context's tenant. Model
+// hooks apply it automatically to Scan, Update and Delete; Count and Exists
+// skip model hooks in bun and must apply it themselves.
+func Scope(ctx context.Context) func(*bun.SelectQuery) *bun.SelectQuery {
+	return func(query *bun.SelectQuery) *bun.SelectQuery {
+		tenantID, ok := FromContext(ctx)
+		if !ok {
+			// Matches nothing rather than everything
+			return query.Where("FALSE")
+		}
+		return query.Where("?TableAlias.tenant_id = ?", tenantID)
+	}
+}
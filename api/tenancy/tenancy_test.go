@@ -0,0 +1,45 @@
+package tenancy
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+type widget struct {
+	bun.BaseModel `bun:"table:widgets,alias:w"`
+
+	ID       uuid.UUID `bun:"id,pk"`
+	TenantID uuid.UUID `bun:"tenant_id"`
+}
+
+func TestScope(t *testing.T) {
+	// Queries are only built, never run
+	db := bun.NewDB(sql.OpenDB(pgdriver.NewConnector()), pgdialect.New())
+	tenantID := uuid.New()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"tenant", WithTenant(context.Background(), tenantID), `WHERE ("w".tenant_id = '` + tenantID.String() + `')`},
+		{"no tenant", context.Background(), `WHERE (FALSE)`},
+		{"nil tenant", WithTenant(context.Background(), uuid.Nil), `WHERE (FALSE)`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Count and Exists wrap the same query, so it is all Scope affects
+			query := db.NewSelect().Model((*widget)(nil)).Apply(Scope(test.ctx)).String()
+			if !strings.HasSuffix(query, test.want) {
+				t.Errorf("query %s does not end in %s", query, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxQueryLength bounds the SQL recorded on a span, as bulk inserts can be long
+const maxQueryLength = 4096
+
+// Instrument adds a child span to the current span for every query run
+// through db. Queries outside a trace, such as those of the background jobs,
+// are not traced.
+func Instrument(db *bun.DB) {
+	db.AddQueryHook(queryHook{})
+}
+
+// querySpanKey holds the span of a query between BeforeQuery and AfterQuery
+type querySpanKey struct{}
+
+// queryHook records a span per query
+type queryHook struct{}
+
+func (queryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	operation := event.Operation()
+	name := operation
+	attributes := []attribute.KeyValue{
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(sanitizedQuery(event)),
+	}
+	if table := tableName(event); table != "" {
+		name += " " + table
+		attributes = append(attributes, semconv.DBCollectionName(table))
+	}
+	ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (queryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+	span.End()
+}
+
+// sanitizedQuery returns the SQL of a query with ? in place of its arguments,
+// so that the values written and looked up never reach the collector. Queries
+// built with bun have their values inlined in the event, so they are rendered
+// again without them.
+func sanitizedQuery(event *bun.QueryEvent) string {
+	query := event.QueryTemplate
+	if event.IQuery != nil {
+		b, err := event.IQuery.AppendQuery(schema.NewNopFormatter(), nil)
+		if err != nil {
+			return ""
+		}
+		query = string(b)
+	}
+	if len(query) > maxQueryLength {
+		query = query[:maxQueryLength] + "…"
+	}
+	return query
+}
+
+// tableName returns the table a query built with bun works on, if any
+func tableName(event *bun.QueryEvent) string {
+	if event.IQuery == nil {
+		return ""
+	}
+	return event.IQuery.GetTableName()
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a span for every request, continuing the trace of the
+// traceparent header when the caller sent one. The span is named after the
+// route that answered, such as GET /products/:id, and is the parent of the
+// spans of the request's queries.
+func Middleware(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), headerCarrier{&c.Request().Header})
+	ctx, span := tracer().Start(ctx, c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.UserAgentOriginal(string(c.Request().Header.UserAgent())),
+			semconv.ClientAddress(c.IP()),
+		),
+	)
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+
+	// Errors are only turned into responses once the request has passed back
+	// through the middleware, so their status is taken from the problem
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = problem.From(err).Status
+	}
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		span.SetAttributes(attribute.String("request.id", requestID))
+	}
+	if status >= fiber.StatusInternalServerError {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Error, fasthttp.StatusMessage(status))
+	}
+	return err
+}
+
+// headerCarrier reads and writes trace context in request headers
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
@@ -0,0 +1,65 @@
+// Package tracing traces requests and the queries they run with
+// OpenTelemetry, and exports the spans to an OTLP collector.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer the API's spans are created with
+const instrumentation = "devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tracing"
+
+// Config says where spans are exported to and how many requests are traced
+type Config struct {
+	// Endpoint is the URL of an OTLP/HTTP collector, such as http://localhost:4318
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of requests traced when the caller did not
+	// decide; requests that carry a trace context follow the caller's choice
+	SampleRatio float64
+}
+
+// tracer creates the spans. It does nothing until Setup installs a provider.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Setup exports spans as config says and propagates W3C trace context. The
+// returned function flushes the spans still buffered and stops the exporter.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.Endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	service, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(config.ServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(service),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
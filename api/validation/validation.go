@@ -0,0 +1,232 @@
+// Package validation checks values against rules declared in `validate`
+// struct tags. Every failing field is reported, not just the first, so a
+// client can fix a request in one go.
+//
+// Rules are separated by commas:
+//
+//	required   the value is not empty: non-blank strings, non-zero numbers
+//	           and UUIDs, non-empty slices and maps, non-nil pointers
+//	min=N      numbers are at least N; strings, slices and maps have at least N elements
+//	max=N      numbers are at most N; strings, slices and maps have at most N elements
+//	gt=N       numbers are greater than N
+//	uuid       strings are UUIDs
+//	email      strings are email addresses
+//	oneof=a b  strings are one of the listed values
+//
+// Rules other than required skip empty strings and nil pointers, so optional
+// fields are only checked when they are set. Fields are named after their
+// json tag, or their bun column for models, which have no json tags.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Codes of failed checks. The rules use the code of their name; handlers use
+// the others for checks that need more than the value itself.
+const (
+	Required = "required"
+	Min      = "min"
+	Max      = "max"
+	Gt       = "gt"
+	UUID     = "uuid"
+	Email    = "email"
+	OneOf    = "oneof"
+	// NotFound is used for references to rows that do not exist
+	NotFound = "not_found"
+	// ReadOnly is used for fields a request may not change
+	ReadOnly = "read_only"
+	// Unknown is used for fields that are not defined
+	Unknown = "unknown"
+	// Invalid is used for other malformed values
+	Invalid = "invalid"
+)
+
+// FieldError is one failed check
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists the failed checks of a value. It is an error so it can be
+// returned through code that returns errors, such as transactions.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a failed check
+func (e *Errors) Add(field, code, message string) {
+	*e = append(*e, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Has reports whether a check of the field failed
+func (e Errors) Has(field string) bool {
+	for _, fieldError := range e {
+		if fieldError.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the errors as an error, or nil if there are none
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Struct checks the fields of a struct, or pointer to one, against their rules
+func Struct(value interface{}) Errors {
+	var errs Errors
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	checkStruct(v, &errs)
+	return errs
+}
+
+func checkStruct(v reflect.Value, errs *Errors) {
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		if structField.Anonymous && reflect.Indirect(v.Field(i)).Kind() == reflect.Struct {
+			checkStruct(reflect.Indirect(v.Field(i)), errs)
+			continue
+		}
+		tag := structField.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		checkField(fieldName(structField), v.Field(i), strings.Split(tag, ","), errs)
+	}
+}
+
+// fieldName returns the name a field has in requests
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "bun"} {
+		name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func checkField(name string, value reflect.Value, rules []string, errs *Errors) {
+	empty := isEmpty(value)
+	for _, rule := range rules {
+		if rule == Required {
+			if empty {
+				errs.Add(name, Required, name+" is required")
+				return
+			}
+			break
+		}
+	}
+	if empty && (value.Kind() == reflect.String || value.Kind() == reflect.Pointer) {
+		return
+	}
+	value = reflect.Indirect(value)
+
+	for _, rule := range rules {
+		rule, argument, _ := strings.Cut(rule, "=")
+		if rule == Required {
+			continue
+		}
+		if message := check(value, rule, argument); message != "" {
+			errs.Add(name, rule, name+" "+message)
+			// One message per field is enough to fix it
+			return
+		}
+	}
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+// check applies one rule and returns what is wrong, if anything
+func check(value reflect.Value, rule, argument string) string {
+	switch rule {
+	case Min, Max, Gt:
+		limit, err := strconv.ParseFloat(argument, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid %s rule %q", rule, argument))
+		}
+		size, unit := measure(value)
+		switch {
+		case rule == Min && size < limit && unit != "":
+			return fmt.Sprintf("must have at least %s %s", argument, unit)
+		case rule == Min && size < limit:
+			return "must be at least " + argument
+		case rule == Max && size > limit && unit != "":
+			return fmt.Sprintf("must have at most %s %s", argument, unit)
+		case rule == Max && size > limit:
+			return "must be at most " + argument
+		case rule == Gt && size <= limit:
+			return "must be greater than " + argument
+		}
+	case UUID:
+		if _, err := uuid.Parse(value.String()); err != nil {
+			return "must be a UUID"
+		}
+	case Email:
+		if _, err := mail.ParseAddress(value.String()); err != nil {
+			return "must be an email address"
+		}
+	case OneOf:
+		options := strings.Fields(argument)
+		for _, option := range options {
+			if value.String() == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", rule))
+	}
+	return ""
+}
+
+// measure returns a number's value, or the length of anything else with the
+// unit it is counted in
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), "characters"
+	}
+	return float64(value.Len()), "items"
+}
@@ -2,62 +2,398 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/auth"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/config"
 	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/database"
 	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/handlers"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/logging"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/metrics"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/migrations"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/problem"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/repository"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/storage"
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/tracing"
 	"github.com/gofiber/fiber/v2"
-	"github.com/joho/godotenv"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/uptrace/bun"
 )
 
 func main() {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
-		fmt.Println("Error loading .env file")
+	// Settings come from a YAML file, the environment and flags, in that order
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	db, err := database.ConnectDb()
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	// Initialize tables
 	ctx := context.Background()
-	if err := database.InitializeTables(db, ctx); err != nil {
-		log.Fatalf("Failed to initialize tables: %v", err)
-	}
-
-	app := fiber.New()
-
-	products_endpoints := app.Group("/products")
-	
-	products_endpoints.Get("/", handlers.Getall)
-	products_endpoints.Post("/", handlers.Create)
-	products_endpoints.Get("/:id", handlers.GetOne)
-	products_endpoints.Put("/:id", handlers.Update)
-	products_endpoints.Delete("/:id", handlers.Delete)
-	app.Get("/categories/:categoryId/products", handlers.GetProductsByCategory)
-	app.Get("/suppliers/:supplierId/products", handlers.GetProductsBySupplier)
-
-	categories_endpoints := app.Group("/categories")
-	categories_endpoints.Get("/", handlers.GetAllCategories)
-	categories_endpoints.Post("/", handlers.CreateCategory)
-	categories_endpoints.Get("/:id", handlers.GetOneCategory)
-	categories_endpoints.Put("/:id", handlers.UpdateCategory)
-	categories_endpoints.Delete("/:id", handlers.DeleteCategory)
-
-	suppliers_endpoints := app.Group("/suppliers")
-	suppliers_endpoints.Get("/", handlers.GetAllSuppliers)
-	suppliers_endpoints.Post("/", handlers.CreateSupplier)
-	suppliers_endpoints.Get("/:id", handlers.GetOneSupplier)
-	suppliers_endpoints.Put("/:id", handlers.UpdateSupplier)
-	suppliers_endpoints.Delete("/:id", handlers.DeleteSupplier)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000" // fallback to 3000 if PORT is not set
-	}
-
-	app.Listen(":" + port)
-}
\ No newline at end of file
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("Unknown command %q; the only command is migrate", args[0])
+		}
+		if err := cfg.ValidateDatabase(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		db, err := connect(cfg.Database)
+		if err != nil {
+			log.Fatalf("Error connecting to database: %v", err)
+		}
+		if err := runMigrate(ctx, db, args[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	configureLogging(cfg.Logging)
+
+	db, err := connect(cfg.Database)
+	if err != nil {
+		fatal("Failed to connect to the database", "error", err)
+	}
+	handlers.SetDB(db)
+	if cfg.Features.Metrics {
+		metrics.Instrument(db)
+	}
+
+	stopTracing := func(context.Context) error { return nil }
+	if cfg.Features.Tracing {
+		stopTracing, err = tracing.Setup(ctx, tracing.Config{
+			Endpoint:    cfg.Tracing.Endpoint,
+			ServiceName: cfg.Tracing.ServiceName,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			fatal("Failed to set up tracing", "error", err)
+		}
+		tracing.Instrument(db)
+	}
+
+	// The API only runs against the schema it was built for
+	pending, err := migrations.Pending(ctx, db)
+	if err != nil {
+		fatal("Failed to check migrations", "error", err)
+	}
+	if len(pending) > 0 {
+		fatal("The database schema is behind; run the migrate command first", "pending", pending.String())
+	}
+
+	imageStorage, err := storage.New(storage.Config{
+		Backend:   cfg.Storage.Backend,
+		LocalDir:  cfg.Storage.LocalDir,
+		PublicURL: cfg.Storage.PublicURL,
+		S3: storage.S3Config{
+			Endpoint:  cfg.Storage.S3Endpoint,
+			AccessKey: cfg.Storage.S3Access,
+			SecretKey: cfg.Storage.S3Secret,
+			Bucket:    cfg.Storage.S3Bucket,
+			Region:    cfg.Storage.S3Region,
+			UseSSL:    cfg.Storage.S3UseSSL,
+		},
+	})
+	if err != nil {
+		fatal("Failed to initialize image storage", "error", err)
+	}
+	handlers.ImageStorage = imageStorage
+	handlers.MaxImagePixels = cfg.Storage.MaxImagePixels
+
+	// Background jobs stop when the server shuts down
+	jobs, stopJobs := context.WithCancel(ctx)
+	var running sync.WaitGroup
+	if cfg.Features.Purge {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			handlers.RunPurgeJob(jobs, handlers.PurgeConfig{
+				Retention: cfg.Purge.Retention,
+				Interval:  cfg.Purge.Interval,
+			})
+		}()
+	}
+	if cfg.Features.Metrics {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			handlers.RunKPIJob(jobs, cfg.Metrics.KPIInterval)
+		}()
+	}
+
+	handlers.RequireIfMatch = cfg.Features.RequireIfMatch
+	handlers.IdempotencyTTL = cfg.Features.IdempotencyTTL
+	handlers.RequestTimeout = cfg.Server.RequestTimeout
+	handlers.LongRequestTimeout = cfg.Server.LongRequestTimeout
+
+	auth.Configure(auth.TokenConfig{
+		Secret:     []byte(cfg.Auth.JWTSecret),
+		AccessTTL:  cfg.Auth.AccessTTL,
+		RefreshTTL: cfg.Auth.RefreshTTL,
+	})
+	auth.APIKeyLookup = handlers.LookupAPIKey
+	if err := handlers.BootstrapAdmin(ctx, cfg.Auth.AdminUsername, cfg.Auth.AdminPassword); err != nil {
+		fatal("Failed to create admin user", "error", err)
+	}
+
+	app := fiber.New(fiber.Config{
+		// Leave room for uploading several product images at once
+		BodyLimit: 4 * handlers.MaxImageSize,
+		// Errors returned by handlers and middleware are sent as problem details
+		ErrorHandler: problem.Handler,
+	})
+
+	// Requests run in a context that is canceled once shutdown gives up
+	// waiting for them
+	requests, cancelRequests := context.WithCancel(ctx)
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(requests)
+		return c.Next()
+	})
+
+	// Tag every request with an X-Request-ID, which the audit log and every
+	// log record of the request carry
+	app.Use(requestid.New())
+	app.Use(logging.Middleware)
+
+	if cfg.Features.Metrics {
+		app.Use(metrics.Middleware)
+		app.Get("/metrics", metrics.Handler())
+	}
+	if cfg.Features.Tracing {
+		app.Use(tracing.Middleware)
+	}
+
+	// Probes for load balancers and orchestrators, without authentication
+	app.Get("/healthz", handlers.Healthz)
+	app.Get("/readyz", handlers.Readyz)
+
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:     strings.Join(cfg.CORS.AllowedOrigins, ","),
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			AllowHeaders:     "Authorization, Content-Type, If-Match, Idempotency-Key, X-API-Key, X-Request-ID",
+			ExposeHeaders:    "ETag, Location, Idempotent-Replayed, X-Request-ID",
+			MaxAge:           int(cfg.CORS.MaxAge.Seconds()),
+		}))
+	}
+
+	// Every request, and every query it runs, is canceled at its deadline
+	app.Use(handlers.Deadline(handlers.RequestTimeout))
+	longRequest := handlers.Deadline(handlers.LongRequestTimeout)
+
+	// The local backend's files are served by the API itself, only to the
+	// tenant whose products they show
+	if local, ok := imageStorage.(*storage.LocalStorage); ok {
+		app.Get(local.PublicPath+"/*", auth.Authenticate, handlers.TenantScope, auth.Require(auth.ProductsRead), handlers.GetStoredImage)
+	}
+
+	auth_endpoints := app.Group("/auth")
+	auth_endpoints.Post("/login", handlers.Login)
+	auth_endpoints.Post("/refresh", handlers.RefreshTokens)
+	auth_endpoints.Post("/logout", handlers.Logout)
+	auth_endpoints.Get("/me", auth.Authenticate, handlers.GetCurrentUser)
+
+	users_endpoints := app.Group("/users", auth.Authenticate, handlers.TenantScope, auth.Require(auth.UsersManage))
+	users_endpoints.Get("/", handlers.GetAllUsers)
+	users_endpoints.Post("/", handlers.CreateUser)
+	users_endpoints.Put("/:id", handlers.UpdateUser)
+	users_endpoints.Delete("/:id", handlers.DeleteUser)
+
+	api_keys_endpoints := app.Group("/api-keys", auth.Authenticate, handlers.TenantScope, auth.Require(auth.UsersManage))
+	api_keys_endpoints.Get("/", handlers.GetAllAPIKeys)
+	api_keys_endpoints.Post("/", handlers.CreateAPIKey)
+	api_keys_endpoints.Post("/:id/rotate", handlers.RotateAPIKey)
+	api_keys_endpoints.Delete("/:id", handlers.RevokeAPIKey)
+
+	tenants_endpoints := app.Group("/tenants", auth.Authenticate, handlers.TenantScope, auth.Require(auth.TenantsManage))
+	tenants_endpoints.Get("/", handlers.GetAllTenants)
+	tenants_endpoints.Post("/", handlers.CreateTenant)
+
+	logging_endpoints := app.Group("/logging", auth.Authenticate, handlers.TenantScope, auth.Require(auth.TenantsManage))
+	logging_endpoints.Get("/level", handlers.GetLogLevel)
+	logging_endpoints.Put("/level", handlers.SetLogLevel)
+
+	audit_endpoints := app.Group("/audit", auth.Authenticate, handlers.TenantScope, auth.Require(auth.AuditRead))
+	audit_endpoints.Get("/", handlers.GetAuditLog)
+
+	// The product, category, supplier and order handlers reach the database
+	// through repositories; the other handlers still query it themselves
+	repos := repository.NewBun(db)
+	productHandler := handlers.NewProductHandler(repos)
+	categoryHandler := handlers.NewCategoryHandler(repos)
+	supplierHandler := handlers.NewSupplierHandler(repos)
+	orderHandler := handlers.NewOrderHandler(repos)
+
+	canReadProducts := auth.Require(auth.ProductsRead)
+	canWriteProducts := auth.Require(auth.ProductsWrite)
+
+	products_endpoints := app.Group("/products", auth.Authenticate, handlers.TenantScope)
+
+	products_endpoints.Get("/", canReadProducts, productHandler.List)
+	products_endpoints.Post("/", canWriteProducts, handlers.Idempotent, productHandler.Create)
+	products_endpoints.Post("/bulk", canWriteProducts, longRequest, handlers.Idempotent, productHandler.Bulk)
+	products_endpoints.Get("/:id", canReadProducts, productHandler.Get)
+	products_endpoints.Put("/:id", canWriteProducts, productHandler.Update)
+	products_endpoints.Patch("/:id", canWriteProducts, productHandler.Patch)
+	products_endpoints.Delete("/:id", auth.Require(auth.ProductsDelete), productHandler.Delete)
+	products_endpoints.Post("/:id/restore", auth.Require(auth.ProductsDelete), productHandler.Restore)
+	products_endpoints.Get("/:id/history", auth.Require(auth.AuditRead), handlers.GetProductHistory)
+	products_endpoints.Get("/:id/components", canReadProducts, handlers.GetKitComponents)
+	products_endpoints.Put("/:id/components", canWriteProducts, handlers.SetKitComponents)
+	products_endpoints.Post("/:id/assemble", auth.Require(auth.StockWrite), handlers.AssembleKit)
+	products_endpoints.Post("/:id/disassemble", auth.Require(auth.StockWrite), handlers.DisassembleKit)
+	products_endpoints.Post("/:id/sell", auth.Require(auth.SalesWrite), handlers.SellKit)
+	products_endpoints.Get("/:id/units", canReadProducts, handlers.GetProductUnits)
+	products_endpoints.Post("/:id/units", canWriteProducts, handlers.CreateProductUnit)
+	products_endpoints.Delete("/:id/units/:unitId", canWriteProducts, handlers.DeleteProductUnit)
+	products_endpoints.Post("/:id/receive", auth.Require(auth.StockWrite), handlers.ReceiveStock)
+	products_endpoints.Get("/:id/images", canReadProducts, handlers.GetProductImages)
+	products_endpoints.Post("/:id/images", canWriteProducts, longRequest, handlers.UploadProductImages)
+	products_endpoints.Put("/:id/images/order", canWriteProducts, handlers.ReorderProductImages)
+	products_endpoints.Delete("/:id/images/:imageId", canWriteProducts, handlers.DeleteProductImage)
+
+	canReadCategories := auth.Require(auth.CategoriesRead)
+	canWriteCategories := auth.Require(auth.CategoriesWrite)
+
+	categories_endpoints := app.Group("/categories", auth.Authenticate, handlers.TenantScope)
+	categories_endpoints.Get("/", canReadCategories, categoryHandler.List)
+	categories_endpoints.Post("/", canWriteCategories, handlers.Idempotent, categoryHandler.Create)
+	categories_endpoints.Post("/bulk", canWriteCategories, longRequest, handlers.Idempotent, categoryHandler.Bulk)
+	categories_endpoints.Get("/tree", canReadCategories, categoryHandler.Tree)
+	categories_endpoints.Get("/:id", canReadCategories, categoryHandler.Get)
+	categories_endpoints.Put("/:id", canWriteCategories, categoryHandler.Update)
+	categories_endpoints.Patch("/:id", canWriteCategories, categoryHandler.Patch)
+	categories_endpoints.Delete("/:id", auth.Require(auth.CategoriesDelete), categoryHandler.Delete)
+	categories_endpoints.Post("/:id/restore", auth.Require(auth.CategoriesDelete), categoryHandler.Restore)
+	categories_endpoints.Put("/:id/move", canWriteCategories, categoryHandler.Move)
+	categories_endpoints.Get("/:id/attributes", canReadCategories, categoryHandler.Attributes)
+	categories_endpoints.Post("/:id/attributes", canWriteCategories, categoryHandler.CreateAttribute)
+	categories_endpoints.Put("/:id/attributes/:attributeId", canWriteCategories, categoryHandler.UpdateAttribute)
+	categories_endpoints.Delete("/:id/attributes/:attributeId", canWriteCategories, categoryHandler.DeleteAttribute)
+	categories_endpoints.Get("/:categoryId/products", canReadProducts, productHandler.ListByCategory)
+
+	canReadSuppliers := auth.Require(auth.SuppliersRead)
+	canWriteSuppliers := auth.Require(auth.SuppliersWrite)
+
+	suppliers_endpoints := app.Group("/suppliers", auth.Authenticate, handlers.TenantScope)
+	suppliers_endpoints.Get("/", canReadSuppliers, supplierHandler.List)
+	suppliers_endpoints.Post("/", canWriteSuppliers, handlers.Idempotent, supplierHandler.Create)
+	suppliers_endpoints.Post("/bulk", canWriteSuppliers, longRequest, handlers.Idempotent, supplierHandler.Bulk)
+	suppliers_endpoints.Get("/:id", canReadSuppliers, supplierHandler.Get)
+	suppliers_endpoints.Put("/:id", canWriteSuppliers, supplierHandler.Update)
+	suppliers_endpoints.Patch("/:id", canWriteSuppliers, supplierHandler.Patch)
+	suppliers_endpoints.Delete("/:id", auth.Require(auth.SuppliersDelete), supplierHandler.Delete)
+	suppliers_endpoints.Post("/:id/restore", auth.Require(auth.SuppliersDelete), supplierHandler.Restore)
+	suppliers_endpoints.Get("/:supplierId/products", canReadProducts, productHandler.ListBySupplier)
+
+	orders_endpoints := app.Group("/orders", auth.Authenticate, handlers.TenantScope)
+	orders_endpoints.Post("/", auth.Require(auth.SalesWrite), handlers.Idempotent, orderHandler.Create)
+	orders_endpoints.Patch("/:id", auth.Require(auth.SalesWrite), orderHandler.Patch)
+	orders_endpoints.Get("/:id/items", auth.Require(auth.SalesWrite), handlers.GetAllOrderItems)
+	orders_endpoints.Post("/:id/items", auth.Require(auth.SalesWrite), handlers.CreateOrderItem)
+	orders_endpoints.Get("/:id/items/:itemId", auth.Require(auth.SalesWrite), handlers.GetOneOrderItem)
+	orders_endpoints.Put("/:id/items/:itemId", auth.Require(auth.SalesWrite), handlers.UpdateOrderItem)
+	orders_endpoints.Delete("/:id/items/:itemId", auth.Require(auth.SalesWrite), handlers.DeleteOrderItem)
+
+	canOrderStock := auth.Require(auth.StockWrite)
+
+	purchase_orders_endpoints := app.Group("/purchase-orders", auth.Authenticate, handlers.TenantScope)
+	purchase_orders_endpoints.Get("/", canOrderStock, handlers.ListPurchaseOrders)
+	purchase_orders_endpoints.Post("/", canOrderStock, handlers.Idempotent, handlers.CreatePurchaseOrder)
+	purchase_orders_endpoints.Get("/:id", canOrderStock, handlers.GetPurchaseOrder)
+	purchase_orders_endpoints.Post("/:id/receive", canOrderStock, handlers.ReceivePurchaseOrder)
+	purchase_orders_endpoints.Post("/:id/cancel", canOrderStock, handlers.CancelPurchaseOrder)
+
+	address := ":" + strconv.Itoa(cfg.Server.Port)
+	listenErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS.Enabled() {
+			listenErr <- app.ListenTLS(address, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			listenErr <- app.Listen(address)
+		}
+	}()
+
+	signals, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-listenErr:
+		fatal("Server stopped", "error", err)
+	case <-signals.Done():
+	}
+	stopSignals()
+	shutdown(app, cfg.Server, cancelRequests)
+
+	stopJobs()
+	running.Wait()
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close the database", "error", err)
+	}
+	// Send the spans of the last requests before exiting
+	flush, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := stopTracing(flush); err != nil {
+		slog.Warn("Failed to export the last traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// shutdown stops the server: /readyz fails for the shutdown delay so load
+// balancers stop sending requests, then the listener is closed and requests
+// in flight get until the shutdown timeout to finish before they are canceled
+func shutdown(app *fiber.App, settings config.Server, cancelRequests context.CancelFunc) {
+	slog.Info("Shutting down", "shutdown_delay", settings.ShutdownDelay, "shutdown_timeout", settings.ShutdownTimeout)
+	handlers.StartDraining()
+	time.Sleep(settings.ShutdownDelay)
+
+	if err := app.ShutdownWithTimeout(settings.ShutdownTimeout); err != nil {
+		slog.Warn("Requests were still running at shutdown", "error", err)
+	}
+	// Cancel what is still running, so its queries stop before the database
+	// connections are closed
+	cancelRequests()
+}
+
+// connect opens the connection pool described by the database settings
+func connect(settings config.Database) (*bun.DB, error) {
+	return database.ConnectDb(settings.URL, database.PoolConfig{
+		MaxOpenConns:    settings.MaxOpenConns,
+		MaxIdleConns:    settings.MaxIdleConns,
+		ConnMaxLifetime: settings.ConnMaxLifetime,
+		ConnMaxIdleTime: settings.ConnMaxIdleTime,
+	})
+}
+
+// fatal logs an error that keeps the server from running and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// configureLogging sends the log, including what is written with the log
+// package, through a handler of the configured format and level
+func configureLogging(settings config.Logging) {
+	var level slog.Level
+	// The level was checked by config.Validate
+	_ = level.UnmarshalText([]byte(settings.Level))
+	logging.Level.Set(level)
+
+	// Records logged with the context of a traced request carry its IDs
+	handler := tracing.LogHandler(logging.NewHandler(os.Stderr, settings.Format))
+	slog.SetDefault(slog.New(handler))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"devops.zedeks.com/TheHiddenDeveloper/ims-zedeks/api/migrations"
+	"github.com/uptrace/bun"
+)
+
+// runMigrate carries out `migrate [up | rollback | status]`. up applies every
+// pending migration as one group, rollback undoes the last group, and status
+// lists every migration and whether it has been applied.
+func runMigrate(ctx context.Context, db *bun.DB, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	migrator := migrations.NewMigrator(db)
+	if err := migrator.Init(ctx); err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		if err := migrator.Lock(ctx); err != nil {
+			return err
+		}
+		defer migrator.Unlock(ctx)
+
+		group, err := migrator.Migrate(ctx)
+		if err != nil && group != nil && len(group.Migrations) > 0 {
+			// The migrations before the failed one stay applied
+			return fmt.Errorf("%s: %w", group.Migrations[len(group.Migrations)-1], err)
+		}
+		if err != nil {
+			return err
+		}
+		if group.IsZero() {
+			fmt.Println("The schema is up to date")
+			return nil
+		}
+		fmt.Printf("Migrated to %s\n", group)
+
+	case "rollback":
+		if err := migrator.Lock(ctx); err != nil {
+			return err
+		}
+		defer migrator.Unlock(ctx)
+
+		group, err := migrator.Rollback(ctx)
+		if err != nil {
+			return err
+		}
+		if group.IsZero() {
+			fmt.Println("There is nothing to roll back")
+			return nil
+		}
+		fmt.Printf("Rolled back %s\n", group)
+
+	case "status":
+		all, err := migrator.MigrationsWithStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range all {
+			if migration.IsApplied() {
+				fmt.Printf("applied  %s (group #%d, %s)\n", migration, migration.GroupID, migration.MigratedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("pending  %s\n", migration)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q; use up, rollback or status", command)
+	}
+	return nil
+}